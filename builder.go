@@ -0,0 +1,285 @@
+package csp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Source is a source expression in a source list, such as 'self' or
+// https://example.com.
+type Source string
+
+// Keyword sources.
+const (
+	None          Source = "'none'"
+	Self          Source = "'self'"
+	UnsafeInline  Source = "'unsafe-inline'"
+	UnsafeEval    Source = "'unsafe-eval'"
+	UnsafeHashes  Source = "'unsafe-hashes'"
+	StrictDynamic Source = "'strict-dynamic'"
+	ReportSample  Source = "'report-sample'"
+)
+
+// Nonce returns a nonce source. PolicyBuilder rejects nonces that aren't
+// base64 or base64url.
+func Nonce(nonce string) Source {
+	return Source("'nonce-" + nonce + "'")
+}
+
+// Hash returns a hash source for the base64 encoded hash value. The algorithm
+// is one of sha256, sha384 or sha512. PolicyBuilder rejects values that aren't
+// base64 or base64url.
+func Hash(algorithm, value string) Source {
+	return Source("'" + algorithm + "-" + value + "'")
+}
+
+// SHA256 returns a hash source that matches the inline content body.
+func SHA256(body []byte) Source {
+	sum := sha256.Sum256(body)
+	return Hash("sha256", base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// Host returns a host source such as https://*.example.com:443.
+func Host(host string) Source {
+	return Source(host)
+}
+
+// Scheme returns a scheme source such as https:.
+func Scheme(scheme string) Source {
+	return Source(strings.TrimSuffix(scheme, ":") + ":")
+}
+
+// PolicyBuilder builds a Policy. Errors are deferred until Build is called so
+// calls can be chained.
+type PolicyBuilder struct {
+	names      []string
+	directives map[string][]string
+	err        error
+}
+
+// NewPolicy returns a builder for a new policy.
+func NewPolicy() *PolicyBuilder {
+	return &PolicyBuilder{
+		directives: map[string][]string{},
+	}
+}
+
+// Directive adds sources to the directive with the provided name.
+func (b *PolicyBuilder) Directive(name string, sources ...Source) *PolicyBuilder {
+	if b.err != nil {
+		return b
+	}
+	if !isToken(name) {
+		b.err = errors.Errorf("invalid directive name %q", name)
+		return b
+	}
+	if _, ok := b.directives[name]; !ok {
+		b.names = append(b.names, name)
+		b.directives[name] = nil
+	}
+	for _, source := range sources {
+		if !isToken(string(source)) {
+			b.err = errors.Errorf("invalid source %q for %s", source, name)
+			return b
+		}
+		if !isBase64Source(string(source)) {
+			b.err = errors.Errorf("invalid base64 value in source %q for %s", source, name)
+			return b
+		}
+		b.directives[name] = append(b.directives[name], string(source))
+	}
+	return b
+}
+
+// isToken returns whether s can be used as a single token in a policy without
+// changing how it's split into directives and policies.
+func isToken(s string) bool {
+	return len(s) > 0 && !strings.ContainsAny(s, " \t\r\n\f;,")
+}
+
+// isBase64Source returns false for nonce and hash sources whose value doesn't
+// match the base64-value grammar. Other sources are always valid.
+func isBase64Source(source string) bool {
+	if len(source) < 2 || !strings.HasPrefix(source, "'") || !strings.HasSuffix(source, "'") {
+		return true
+	}
+	parts := strings.SplitN(source[1:len(source)-1], "-", 2)
+	if len(parts) != 2 {
+		return true
+	}
+	if _, ok := hashAlgorithms[parts[0]]; !ok && parts[0] != "nonce" {
+		return true
+	}
+	return base64ValueRegex.MatchString(parts[1])
+}
+
+// DefaultSrc adds sources to default-src.
+func (b *PolicyBuilder) DefaultSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("default-src", sources...)
+}
+
+// ScriptSrc adds sources to script-src.
+func (b *PolicyBuilder) ScriptSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("script-src", sources...)
+}
+
+// ScriptSrcElem adds sources to script-src-elem.
+func (b *PolicyBuilder) ScriptSrcElem(sources ...Source) *PolicyBuilder {
+	return b.Directive("script-src-elem", sources...)
+}
+
+// ScriptSrcAttr adds sources to script-src-attr.
+func (b *PolicyBuilder) ScriptSrcAttr(sources ...Source) *PolicyBuilder {
+	return b.Directive("script-src-attr", sources...)
+}
+
+// StyleSrc adds sources to style-src.
+func (b *PolicyBuilder) StyleSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("style-src", sources...)
+}
+
+// StyleSrcElem adds sources to style-src-elem.
+func (b *PolicyBuilder) StyleSrcElem(sources ...Source) *PolicyBuilder {
+	return b.Directive("style-src-elem", sources...)
+}
+
+// StyleSrcAttr adds sources to style-src-attr.
+func (b *PolicyBuilder) StyleSrcAttr(sources ...Source) *PolicyBuilder {
+	return b.Directive("style-src-attr", sources...)
+}
+
+// ImgSrc adds sources to img-src.
+func (b *PolicyBuilder) ImgSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("img-src", sources...)
+}
+
+// FontSrc adds sources to font-src.
+func (b *PolicyBuilder) FontSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("font-src", sources...)
+}
+
+// ConnectSrc adds sources to connect-src.
+func (b *PolicyBuilder) ConnectSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("connect-src", sources...)
+}
+
+// MediaSrc adds sources to media-src.
+func (b *PolicyBuilder) MediaSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("media-src", sources...)
+}
+
+// ObjectSrc adds sources to object-src.
+func (b *PolicyBuilder) ObjectSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("object-src", sources...)
+}
+
+// FrameSrc adds sources to frame-src.
+func (b *PolicyBuilder) FrameSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("frame-src", sources...)
+}
+
+// ChildSrc adds sources to child-src.
+func (b *PolicyBuilder) ChildSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("child-src", sources...)
+}
+
+// WorkerSrc adds sources to worker-src.
+func (b *PolicyBuilder) WorkerSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("worker-src", sources...)
+}
+
+// ManifestSrc adds sources to manifest-src.
+func (b *PolicyBuilder) ManifestSrc(sources ...Source) *PolicyBuilder {
+	return b.Directive("manifest-src", sources...)
+}
+
+// BaseURI adds sources to base-uri.
+func (b *PolicyBuilder) BaseURI(sources ...Source) *PolicyBuilder {
+	return b.Directive("base-uri", sources...)
+}
+
+// FormAction adds sources to form-action.
+func (b *PolicyBuilder) FormAction(sources ...Source) *PolicyBuilder {
+	return b.Directive("form-action", sources...)
+}
+
+// FrameAncestors adds sources to frame-ancestors.
+func (b *PolicyBuilder) FrameAncestors(sources ...Source) *PolicyBuilder {
+	return b.Directive("frame-ancestors", sources...)
+}
+
+// Sandbox adds the sandbox directive with flags such as allow-scripts.
+func (b *PolicyBuilder) Sandbox(flags ...string) *PolicyBuilder {
+	sources := make([]Source, len(flags))
+	for i, flag := range flags {
+		sources[i] = Source(flag)
+	}
+	return b.Directive("sandbox", sources...)
+}
+
+// RequireSRIFor adds the require-sri-for directive with the resource types,
+// script and/or style, that must have integrity metadata.
+func (b *PolicyBuilder) RequireSRIFor(types ...string) *PolicyBuilder {
+	sources := make([]Source, len(types))
+	for i, t := range types {
+		sources[i] = Source(t)
+	}
+	return b.Directive("require-sri-for", sources...)
+}
+
+// UpgradeInsecureRequests adds the upgrade-insecure-requests directive.
+func (b *PolicyBuilder) UpgradeInsecureRequests() *PolicyBuilder {
+	return b.Directive("upgrade-insecure-requests")
+}
+
+// BlockAllMixedContent adds the block-all-mixed-content directive.
+func (b *PolicyBuilder) BlockAllMixedContent() *PolicyBuilder {
+	return b.Directive("block-all-mixed-content")
+}
+
+// ReportURI adds URLs to the report-uri directive.
+func (b *PolicyBuilder) ReportURI(uris ...string) *PolicyBuilder {
+	sources := make([]Source, len(uris))
+	for i, uri := range uris {
+		sources[i] = Source(uri)
+	}
+	return b.Directive("report-uri", sources...)
+}
+
+// ReportTo adds the report-to directive with the endpoint group name.
+func (b *PolicyBuilder) ReportTo(group string) *PolicyBuilder {
+	return b.Directive("report-to", Source(group))
+}
+
+// String returns the policy as a header value without validating it.
+func (b *PolicyBuilder) String() string {
+	directives := make([]string, len(b.names))
+	for i, name := range b.names {
+		directives[i] = strings.Join(append([]string{name}, b.directives[name]...), " ")
+	}
+	return strings.Join(directives, "; ")
+}
+
+// Build validates and returns the policy. It returns the first error from
+// building the policy or any error from parsing it.
+func (b *PolicyBuilder) Build() (Policy, error) {
+	if b.err != nil {
+		return Policy{}, b.err
+	}
+	if len(b.names) == 0 {
+		return Policy{}, errors.Errorf("policy has no directives")
+	}
+	return ParsePolicy(b.String())
+}
+
+// MustBuild is like Build but panics if the policy is invalid.
+func (b *PolicyBuilder) MustBuild() Policy {
+	p, err := b.Build()
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
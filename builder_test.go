@@ -0,0 +1,98 @@
+package csp
+
+import (
+	"testing"
+)
+
+func TestPolicyBuilder(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		builder *PolicyBuilder
+		want    string
+		err     string
+	}{
+		{
+			builder: NewPolicy().DefaultSrc(Self).ScriptSrc(Nonce("foo"), Host("cdn.example.com")),
+			want:    "default-src 'self'; script-src 'nonce-foo' cdn.example.com",
+		},
+		{
+			builder: NewPolicy().ImgSrc(Self).ImgSrc(Scheme("data"), Scheme("https:")).UpgradeInsecureRequests(),
+			want:    "img-src 'self' data: https:; upgrade-insecure-requests",
+		},
+		{
+			builder: NewPolicy().StyleSrc(UnsafeHashes, SHA256([]byte("foo"))),
+			want:    "style-src 'unsafe-hashes' 'sha256-LCa0a2j/xo/5m0U8HTBBNBNCLXBkg7+g+YpeiGJm564='",
+		},
+		{
+			builder: NewPolicy().ScriptSrc(StrictDynamic, Hash("sha384", "abc"), ReportSample).ObjectSrc(None).BaseURI(None),
+			want:    "base-uri 'none'; object-src 'none'; script-src 'strict-dynamic' 'report-sample' 'sha384-abc'",
+		},
+		{
+			builder: NewPolicy().DefaultSrc(Self).ReportURI("/csp", "https://example.com/csp").ReportTo("csp"),
+			want:    "default-src 'self'; report-to csp; report-uri /csp https://example.com/csp",
+		},
+		{
+			builder: NewPolicy().DefaultSrc(Self).Sandbox("allow-scripts", "allow-same-origin"),
+			want:    "default-src 'self'; sandbox allow-same-origin allow-scripts",
+		},
+		{
+			builder: NewPolicy().ScriptSrc(Self).RequireSRIFor("script"),
+			want:    "require-sri-for script; script-src 'self'",
+		},
+		{
+			builder: NewPolicy().ScriptSrc(Nonce("a-b_c="), Hash("sha256", "a-b_c+/=")),
+			want:    "script-src 'nonce-a-b_c=' 'sha256-a-b_c+/='",
+		},
+		{
+			builder: NewPolicy().DefaultSrc(None, Self),
+			err:     "'none' must only be specified",
+		},
+		{
+			builder: NewPolicy().ScriptSrc(Host("foo.com; img-src *")),
+			err:     "invalid source",
+		},
+		{
+			builder: NewPolicy().ScriptSrc(Nonce("a b")),
+			err:     "invalid source",
+		},
+		{
+			builder: NewPolicy().ScriptSrc(Nonce("a=b")),
+			err:     "invalid base64 value",
+		},
+		{
+			builder: NewPolicy().ScriptSrc(Hash("sha256", "a'b")),
+			err:     "invalid base64 value",
+		},
+		{
+			builder: NewPolicy().Directive("foo-src", Self),
+			err:     "unknown directive",
+		},
+		{
+			builder: NewPolicy(),
+			err:     "no directives",
+		},
+	}
+
+	for i, c := range cases {
+		p, err := c.builder.Build()
+		checkErr(t, err, c.err)
+		if err != nil {
+			continue
+		}
+		if got := p.String(); got != c.want {
+			t.Errorf("%d. Build() = %q; not %q", i, got, c.want)
+		}
+	}
+}
+
+func TestPolicyBuilderMustBuild(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected MustBuild to panic")
+		}
+	}()
+	NewPolicy().Directive("foo-src").MustBuild()
+}
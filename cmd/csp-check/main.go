@@ -0,0 +1,218 @@
+// Command csp-check validates HTML and CSS files against a Content Security
+// Policy.
+//
+// Usage:
+//
+//	csp-check -policy "default-src 'self'" [flags] <file or directory>...
+//
+// The policy can be provided as a string with -policy, read from a file with
+// -policy-file or extracted from the headers of a saved HTTP response with
+// -response. Directories are searched recursively for .html, .htm and .css
+// files. Violations are printed to stdout and the command exits with a non-zero
+// status if any enforced policy is violated.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	csp "github.com/d4l3k/go-csp-engine"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	code, err := run(os.Args[1:], os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "csp-check: %+v\n", err)
+	}
+	os.Exit(code)
+}
+
+// run runs the command with the provided arguments and returns the exit code.
+func run(args []string, out io.Writer) (int, error) {
+	fs := flag.NewFlagSet("csp-check", flag.ContinueOnError)
+	policy := fs.String("policy", "", "the policy to check against")
+	policyFile := fs.String("policy-file", "", "a file containing the policy to check against")
+	response := fs.String("response", "", "a saved HTTP response to read the policy headers from")
+	reportOnly := fs.Bool("report-only", false, "treat the -policy and -policy-file policies as report-only")
+	base := fs.String("url", "http://localhost/", "the URL the files are served from")
+	if err := fs.Parse(args); err != nil {
+		return 2, err
+	}
+	if fs.NArg() == 0 {
+		return 2, errors.Errorf("no files to check")
+	}
+
+	ps, err := loadPolicies(*policy, *policyFile, *response, *reportOnly)
+	if err != nil {
+		return 2, err
+	}
+	if len(ps) == 0 {
+		return 2, errors.Errorf("no policy specified")
+	}
+
+	baseURL, err := url.Parse(*base)
+	if err != nil {
+		return 2, err
+	}
+
+	valid := true
+	for _, root := range fs.Args() {
+		files, err := findFiles(root)
+		if err != nil {
+			return 2, err
+		}
+		for _, file := range files {
+			fileValid, err := checkFile(ps, *baseURL, root, file, out)
+			if err != nil {
+				return 2, errors.Wrapf(err, "checking %s", file)
+			}
+			valid = valid && fileValid
+		}
+	}
+	if !valid {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// loadPolicies loads the policies from all of the specified sources.
+func loadPolicies(policy, policyFile, response string, reportOnly bool) (csp.PolicySet, error) {
+	var ps csp.PolicySet
+	add := func(header string) error {
+		policies, err := csp.ParsePolicySet(header)
+		if err != nil {
+			return err
+		}
+		for _, p := range policies {
+			if reportOnly {
+				p.Disposition = csp.DispositionReport
+			}
+			ps = append(ps, p)
+		}
+		return nil
+	}
+
+	if len(policy) > 0 {
+		if err := add(policy); err != nil {
+			return nil, err
+		}
+	}
+	if len(policyFile) > 0 {
+		body, err := ioutil.ReadFile(policyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(string(body)); err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", policyFile)
+		}
+	}
+	if len(response) > 0 {
+		f, err := os.Open(response)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		resp, err := http.ReadResponse(bufio.NewReader(f), nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", response)
+		}
+		defer resp.Body.Close()
+		policies, err := csp.ParseHeader(resp.Header)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", response)
+		}
+		ps = append(ps, policies...)
+	}
+	return ps, nil
+}
+
+// checkedExtensions are the file extensions that are validated.
+var checkedExtensions = map[string]bool{
+	".html": true,
+	".htm":  true,
+	".css":  true,
+}
+
+// findFiles returns the files to check for the path. If path is a directory,
+// it is searched recursively.
+func findFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && checkedExtensions[strings.ToLower(filepath.Ext(file))] {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// checkFile validates a single file and prints its violations. The page URL of
+// the file is its path relative to root resolved against base.
+func checkFile(ps csp.PolicySet, base url.URL, root, file string, out io.Writer) (bool, error) {
+	rel, err := filepath.Rel(root, file)
+	if err != nil || rel == "." {
+		rel = filepath.Base(file)
+	}
+	page := *base.ResolveReference(&url.URL{Path: filepath.ToSlash(rel)})
+
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+
+	var valid bool
+	var reports []csp.Report
+	if strings.ToLower(filepath.Ext(file)) == ".css" {
+		valid, reports, err = ps.ValidateStylesheet(page, string(body))
+	} else {
+		valid, reports, err = ps.ValidatePage(page, strings.NewReader(string(body)))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range reports {
+		printReport(out, file, r)
+	}
+	return valid, nil
+}
+
+func printReport(out io.Writer, file string, r csp.Report) {
+	blocked := r.Blocked
+	if len(blocked) == 0 {
+		blocked = "inline"
+	}
+	var prefix string
+	if r.Disposition == csp.DispositionReport {
+		prefix = "[report-only] "
+	}
+	location := file
+	if r.Position.Line > 0 {
+		location = fmt.Sprintf("%s:%d:%d", file, r.Position.Line, r.Position.Column)
+	}
+	var path string
+	if len(r.Position.Path) > 0 {
+		path = " at " + r.Position.Path
+	}
+	fmt.Fprintf(out, "%s: %s%s blocked by %s (effective directive %s)%s\n", location, prefix, blocked, r.ViolatedDirective, r.EffectiveDirective, path)
+}
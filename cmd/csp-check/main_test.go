@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "csp-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"valid/index.html":   `<script src="/app.js"></script>`,
+		"valid/style.css":    `body { background: url(/bg.png); }`,
+		"invalid/index.html": `<script src="https://evil.com/app.js"></script>`,
+		"invalid/style.css":  `body { background: url(https://evil.com/bg.png); }`,
+		"invalid/notes.txt":  `<script src="https://evil.com/app.js"></script>`,
+		"policy.txt":         "default-src 'self'",
+		"response.txt":       "HTTP/1.1 200 OK\r\nContent-Security-Policy: default-src 'self'\r\nContent-Length: 0\r\n\r\n",
+	})
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		args       []string
+		code       int
+		violations int
+	}{
+		{[]string{"-policy", "default-src 'self'", filepath.Join(dir, "valid")}, 0, 0},
+		{[]string{"-policy", "default-src 'self'", filepath.Join(dir, "invalid")}, 1, 2},
+		{[]string{"-policy", "default-src 'self'", filepath.Join(dir, "invalid", "style.css")}, 1, 1},
+		{[]string{"-policy", "default-src 'self'", "-report-only", filepath.Join(dir, "invalid")}, 0, 2},
+		{[]string{"-policy-file", filepath.Join(dir, "policy.txt"), filepath.Join(dir, "invalid")}, 1, 2},
+		{[]string{"-response", filepath.Join(dir, "response.txt"), filepath.Join(dir, "invalid")}, 1, 2},
+		{[]string{"-url", "https://evil.com/", "-policy", "default-src 'self'", filepath.Join(dir, "invalid")}, 0, 0},
+		{[]string{filepath.Join(dir, "valid")}, 2, 0},
+		{[]string{"-policy", "default-src 'self'"}, 2, 0},
+	}
+
+	for i, c := range cases {
+		var out bytes.Buffer
+		code, err := run(c.args, &out)
+		if code != c.code {
+			t.Errorf("%d. run(%q) = %d, %v; not %d", i, c.args, code, err, c.code)
+		}
+		lines := strings.Count(out.String(), "\n")
+		if lines != c.violations {
+			t.Errorf("%d. run(%q) printed %d violations; not %d:\n%s", i, c.args, lines, c.violations, out.String())
+		}
+	}
+}
+
+func TestRunPositions(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"index.html": "<p>hello</p>\n<div id=\"main\">\n  <img src=\"https://evil.com/a.png\">\n</div>\n",
+	})
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	if _, err := run([]string{"-policy", "default-src 'self'", dir}, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "index.html:3:3: https://evil.com/a.png blocked by default-src (effective directive img-src) at html > body > div#main > img\n"
+	if !strings.HasSuffix(out.String(), want) {
+		t.Errorf("run() printed %q; expected suffix %q", out.String(), want)
+	}
+}
@@ -0,0 +1,241 @@
+package csp
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ViolationReport is a CSP violation report sent by a browser. It mirrors
+// Report and uses the field names of the legacy csp-report format.
+type ViolationReport struct {
+	DocumentURI        string `json:"document-uri"`
+	Referrer           string `json:"referrer,omitempty"`
+	BlockedURI         string `json:"blocked-uri"`
+	ViolatedDirective  string `json:"violated-directive,omitempty"`
+	EffectiveDirective string `json:"effective-directive"`
+	OriginalPolicy     string `json:"original-policy"`
+	Disposition        string `json:"disposition"`
+	StatusCode         int    `json:"status-code"`
+	SourceFile         string `json:"source-file,omitempty"`
+	LineNumber         int    `json:"line-number,omitempty"`
+	ColumnNumber       int    `json:"column-number,omitempty"`
+	ScriptSample       string `json:"script-sample,omitempty"`
+}
+
+// legacyReport is the body sent to report-uri endpoints.
+type legacyReport struct {
+	CSPReport ViolationReport `json:"csp-report"`
+}
+
+// ReportingAPIReport is a report sent by the Reporting API to report-to
+// endpoints.
+type ReportingAPIReport struct {
+	Type      string           `json:"type"`
+	Age       int              `json:"age"`
+	URL       string           `json:"url"`
+	UserAgent string           `json:"user_agent"`
+	Body      ReportingAPIBody `json:"body"`
+}
+
+// ReportingAPIBody is the body of a csp-violation Reporting API report.
+type ReportingAPIBody struct {
+	DocumentURL        string `json:"documentURL"`
+	Referrer           string `json:"referrer,omitempty"`
+	BlockedURL         string `json:"blockedURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	OriginalPolicy     string `json:"originalPolicy"`
+	SourceFile         string `json:"sourceFile,omitempty"`
+	Sample             string `json:"sample,omitempty"`
+	Disposition        string `json:"disposition"`
+	StatusCode         int    `json:"statusCode"`
+	LineNumber         int    `json:"lineNumber,omitempty"`
+	ColumnNumber       int    `json:"columnNumber,omitempty"`
+}
+
+// reportingAPIViolationType is the report type of CSP violations.
+const reportingAPIViolationType = "csp-violation"
+
+// ViolationReport converts the report to a ViolationReport. The Reporting API
+// only includes the effective directive, so it's also used as the violated
+// directive.
+func (r ReportingAPIReport) ViolationReport() ViolationReport {
+	return ViolationReport{
+		DocumentURI:        r.Body.DocumentURL,
+		Referrer:           r.Body.Referrer,
+		BlockedURI:         r.Body.BlockedURL,
+		ViolatedDirective:  r.Body.EffectiveDirective,
+		EffectiveDirective: r.Body.EffectiveDirective,
+		OriginalPolicy:     r.Body.OriginalPolicy,
+		Disposition:        r.Body.Disposition,
+		StatusCode:         r.Body.StatusCode,
+		SourceFile:         r.Body.SourceFile,
+		LineNumber:         r.Body.LineNumber,
+		ColumnNumber:       r.Body.ColumnNumber,
+		ScriptSample:       r.Body.Sample,
+	}
+}
+
+// ReportSink stores violation reports.
+type ReportSink interface {
+	StoreReport(ViolationReport) error
+}
+
+// MemorySink stores violation reports in memory.
+type MemorySink struct {
+	mu      sync.Mutex
+	reports []ViolationReport
+}
+
+// StoreReport implements ReportSink.
+func (s *MemorySink) StoreReport(r ViolationReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reports = append(s.reports, r)
+	return nil
+}
+
+// Reports returns all the stored reports.
+func (s *MemorySink) Reports() []ViolationReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]ViolationReport(nil), s.reports...)
+}
+
+// JSONLinesSink writes violation reports as JSON with one report per line.
+type JSONLinesSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewJSONLinesSink returns a sink that writes reports to w.
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w}
+}
+
+// OpenJSONLinesSink returns a sink that appends reports to the file at path.
+func OpenJSONLinesSink(path string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesSink{w: f, closer: f}, nil
+}
+
+// StoreReport implements ReportSink.
+func (s *JSONLinesSink) StoreReport(r ViolationReport) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(line)
+	return err
+}
+
+// Close closes the file opened by OpenJSONLinesSink.
+func (s *JSONLinesSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// defaultMaxReportSize is the default limit of a report request body.
+const defaultMaxReportSize = 64 * 1024
+
+// ReportHandler is an http.Handler that accepts violation reports from
+// browsers. It supports both application/csp-report bodies sent to report-uri
+// endpoints and application/reports+json bodies sent to report-to endpoints.
+type ReportHandler struct {
+	Sink ReportSink
+	// MaxBodySize is the maximum size of a request body. It defaults to 64KiB.
+	MaxBodySize int64
+}
+
+// ServeHTTP implements http.Handler.
+func (h ReportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBodySize := h.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxReportSize
+	}
+	// Read one byte past the limit to tell bodies that are too large apart
+	// from other read errors.
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > maxBodySize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	reports, err := ParseViolationReports(mediaType, body)
+	if err == errUnsupportedReportType {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, report := range reports {
+		if err := h.Sink.StoreReport(report); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var errUnsupportedReportType = errors.New("unsupported report content type")
+
+// ParseViolationReports parses a report request body with the provided media
+// type. Reports of other types in Reporting API bodies are skipped.
+func ParseViolationReports(mediaType string, body []byte) ([]ViolationReport, error) {
+	switch mediaType {
+	case "application/csp-report", "application/json":
+		var report legacyReport
+		if err := json.Unmarshal(body, &report); err != nil {
+			return nil, errors.Wrap(err, "parsing csp-report")
+		}
+		return []ViolationReport{report.CSPReport}, nil
+
+	case "application/reports+json":
+		var reports []ReportingAPIReport
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, errors.Wrap(err, "parsing reports")
+		}
+		var violations []ViolationReport
+		for _, report := range reports {
+			if report.Type == reportingAPIViolationType {
+				violations = append(violations, report.ViolationReport())
+			}
+		}
+		return violations, nil
+
+	default:
+		return nil, errUnsupportedReportType
+	}
+}
@@ -0,0 +1,195 @@
+package csp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const legacyReportBody = `{
+	"csp-report": {
+		"document-uri": "https://example.com/foo",
+		"referrer": "",
+		"blocked-uri": "https://evil.com/evil.js",
+		"violated-directive": "script-src-elem",
+		"effective-directive": "script-src-elem",
+		"original-policy": "script-src 'self'; report-uri /csp",
+		"disposition": "enforce",
+		"status-code": 200,
+		"line-number": 10
+	}
+}`
+
+const reportingAPIBody = `[
+	{
+		"type": "csp-violation",
+		"age": 10,
+		"url": "https://example.com/foo",
+		"user_agent": "Mozilla/5.0",
+		"body": {
+			"documentURL": "https://example.com/foo",
+			"blockedURL": "inline",
+			"effectiveDirective": "style-src-attr",
+			"originalPolicy": "style-src 'self'; report-to csp",
+			"disposition": "report",
+			"statusCode": 200,
+			"sample": "color: red"
+		}
+	},
+	{
+		"type": "deprecation",
+		"age": 10,
+		"url": "https://example.com/foo",
+		"body": {}
+	}
+]`
+
+func TestReportHandler(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		method, contentType, body string
+		code                      int
+		want                      []ViolationReport
+	}{
+		{
+			"POST", "application/csp-report", legacyReportBody, http.StatusNoContent,
+			[]ViolationReport{{
+				DocumentURI:        "https://example.com/foo",
+				BlockedURI:         "https://evil.com/evil.js",
+				ViolatedDirective:  "script-src-elem",
+				EffectiveDirective: "script-src-elem",
+				OriginalPolicy:     "script-src 'self'; report-uri /csp",
+				Disposition:        "enforce",
+				StatusCode:         200,
+				LineNumber:         10,
+			}},
+		},
+		{
+			"POST", "application/reports+json; charset=utf-8", reportingAPIBody, http.StatusNoContent,
+			[]ViolationReport{{
+				DocumentURI:        "https://example.com/foo",
+				BlockedURI:         "inline",
+				ViolatedDirective:  "style-src-attr",
+				EffectiveDirective: "style-src-attr",
+				OriginalPolicy:     "style-src 'self'; report-to csp",
+				Disposition:        "report",
+				StatusCode:         200,
+				ScriptSample:       "color: red",
+			}},
+		},
+		{"GET", "application/csp-report", legacyReportBody, http.StatusMethodNotAllowed, nil},
+		{"POST", "text/plain", legacyReportBody, http.StatusUnsupportedMediaType, nil},
+		{"POST", "application/csp-report", "{", http.StatusBadRequest, nil},
+		{"POST", "application/csp-report", strings.Repeat(" ", defaultMaxReportSize+1), http.StatusRequestEntityTooLarge, nil},
+	}
+
+	for i, c := range cases {
+		sink := &MemorySink{}
+		h := ReportHandler{Sink: sink}
+		req := httptest.NewRequest(c.method, "/csp", strings.NewReader(c.body))
+		req.Header.Set("Content-Type", c.contentType)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != c.code {
+			t.Errorf("%d. status = %d; not %d: %s", i, w.Code, c.code, w.Body.String())
+		}
+		got := sink.Reports()
+		if len(got) != len(c.want) {
+			t.Fatalf("%d. got reports %+v; not %+v", i, got, c.want)
+		}
+		for j := range got {
+			if got[j] != c.want[j] {
+				t.Errorf("%d. report %d = %+v; not %+v", i, j, got[j], c.want[j])
+			}
+		}
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestReportHandlerReadError(t *testing.T) {
+	t.Parallel()
+
+	h := ReportHandler{Sink: &MemorySink{}}
+	req := httptest.NewRequest("POST", "/csp", errReader{})
+	req.Header.Set("Content-Type", "application/csp-report")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d; not %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	sink := NewJSONLinesSink(&buf)
+	reports := []ViolationReport{
+		{DocumentURI: "https://example.com/a", BlockedURI: "inline"},
+		{DocumentURI: "https://example.com/b", BlockedURI: "eval"},
+	}
+	for _, r := range reports {
+		if err := sink.StoreReport(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(reports) {
+		t.Fatalf("expected %d lines; got %q", len(reports), buf.String())
+	}
+	for i, line := range lines {
+		var r ViolationReport
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r != reports[i] {
+			t.Errorf("line %d = %+v; not %+v", i, r, reports[i])
+		}
+	}
+}
+
+func TestOpenJSONLinesSink(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "csp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "reports.jsonl")
+	for i := 0; i < 2; i++ {
+		sink, err := OpenJSONLinesSink(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.StoreReport(ViolationReport{BlockedURI: "inline"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(body), "\n"); n != 2 {
+		t.Errorf("expected reports to be appended; got %q", body)
+	}
+}
@@ -1,24 +1,57 @@
 package csp
 
 import (
+	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 
-	"github.com/gobwas/glob"
 	"github.com/pkg/errors"
 )
 
+// Disposition is whether a policy is enforced or only reports violations.
+type Disposition int
+
+const (
+	// DispositionEnforce blocks resources that violate the policy. This is used
+	// for policies from the Content-Security-Policy header.
+	DispositionEnforce Disposition = iota
+	// DispositionReport only reports violations of the policy. This is used for
+	// policies from the Content-Security-Policy-Report-Only header.
+	DispositionReport
+)
+
+// String returns the disposition as used in violation reports.
+func (d Disposition) String() string {
+	if d == DispositionReport {
+		return "report"
+	}
+	return "enforce"
+}
+
 // Policy represents the entire CSP policy and its directives.
 type Policy struct {
 	Directives              map[string]Directive
 	UpgradeInsecureRequests bool
 	BlockAllMixedContent    bool
+	Disposition             Disposition
+	// ReportURIs are the URLs from the report-uri directive.
+	ReportURIs []string
+	// ReportTo is the endpoint group name from the report-to directive.
+	ReportTo string
+
+	// original is the policy as it was passed to ParsePolicy.
+	original string
+	// sandbox is the sandbox of the document from every policy in its
+	// PolicySet. It's nil if no other policy is known.
+	sandbox *SandboxDirective
 }
 
 // ParsePolicy parses all the directives in a CSP policy.
 func ParsePolicy(policy string) (Policy, error) {
 	p := Policy{
 		Directives: map[string]Directive{},
+		original:   policy,
 	}
 	directiveDefs := strings.Split(policy, ";")
 	for _, directive := range directiveDefs {
@@ -28,20 +61,43 @@ func ParsePolicy(policy string) (Policy, error) {
 		}
 		directiveType := fields[0]
 		switch directiveType {
-		case "base-uri", "child-src", "connect-src", "default-src", "font-src", "form-action", "frame-ancestors", "frame-src", "img-src", "manifest-src", "media-src", "object-src", "script-src", "style-src", "worker-src":
+		case "base-uri", "child-src", "connect-src", "default-src", "font-src", "form-action", "frame-ancestors", "frame-src", "img-src", "manifest-src", "media-src", "object-src", "script-src", "script-src-elem", "script-src-attr", "style-src", "style-src-elem", "style-src-attr", "worker-src":
 			d, err := ParseSourceDirective(fields[1:])
 			if err != nil {
 				return Policy{}, err
 			}
 			p.Directives[directiveType] = d
 
-		case "report-uri":
-			if len(fields) != 2 {
-				return Policy{}, errors.Errorf("report-uri expects 1 field; got %q", directive)
+		case "sandbox":
+			d, err := ParseSandboxDirective(fields[1:])
+			if err != nil {
+				return Policy{}, err
 			}
-			if _, err := url.Parse(fields[1]); err != nil {
+			p.Directives[directiveType] = d
+
+		case "require-sri-for":
+			d, err := ParseRequireSRIForDirective(fields[1:])
+			if err != nil {
 				return Policy{}, err
 			}
+			p.Directives[directiveType] = d
+
+		case "report-uri":
+			if len(fields) < 2 {
+				return Policy{}, errors.Errorf("report-uri expects at least 1 field; got %q", directive)
+			}
+			for _, uri := range fields[1:] {
+				if _, err := url.Parse(uri); err != nil {
+					return Policy{}, err
+				}
+			}
+			p.ReportURIs = append(p.ReportURIs, fields[1:]...)
+
+		case "report-to":
+			if len(fields) != 2 {
+				return Policy{}, errors.Errorf("report-to expects 1 field; got %q", directive)
+			}
+			p.ReportTo = fields[1]
 
 		case "upgrade-insecure-requests":
 			if len(fields) != 1 {
@@ -63,30 +119,149 @@ func ParsePolicy(policy string) (Policy, error) {
 	return p, nil
 }
 
-// Directive returns the first directive that exists in the order: directive
-// with the provided name, default-src, and finally 'none' directive.
+// String returns the policy serialized as a header value. Directives are
+// sorted by name so the output is deterministic.
+func (p Policy) String() string {
+	var directives []string
+	for name, d := range p.Directives {
+		directive := name
+		if stringer, ok := d.(fmt.Stringer); ok {
+			if value := stringer.String(); len(value) > 0 {
+				directive += " " + value
+			}
+		}
+		directives = append(directives, directive)
+	}
+	if p.UpgradeInsecureRequests {
+		directives = append(directives, "upgrade-insecure-requests")
+	}
+	if p.BlockAllMixedContent {
+		directives = append(directives, "block-all-mixed-content")
+	}
+	if len(p.ReportURIs) > 0 {
+		directives = append(directives, "report-uri "+strings.Join(p.ReportURIs, " "))
+	}
+	if len(p.ReportTo) > 0 {
+		directives = append(directives, "report-to "+p.ReportTo)
+	}
+	sort.Strings(directives)
+	return strings.Join(directives, "; ")
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p Policy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Policy) UnmarshalText(text []byte) error {
+	policy, err := ParsePolicy(string(text))
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+// directiveFallbacks are the directives that are checked in order for each
+// effective directive as defined by the CSP3 fallback list. Directives that
+// aren't listed, such as base-uri and form-action, don't fall back.
+var directiveFallbacks = map[string][]string{
+	"script-src-elem": {"script-src-elem", "script-src", "default-src"},
+	"script-src-attr": {"script-src-attr", "script-src", "default-src"},
+	"style-src-elem":  {"style-src-elem", "style-src", "default-src"},
+	"style-src-attr":  {"style-src-attr", "style-src", "default-src"},
+	"worker-src":      {"worker-src", "child-src", "script-src", "default-src"},
+	"connect-src":     {"connect-src", "default-src"},
+	"manifest-src":    {"manifest-src", "default-src"},
+	"prefetch-src":    {"prefetch-src", "default-src"},
+	"object-src":      {"object-src", "default-src"},
+	"frame-src":       {"frame-src", "child-src", "default-src"},
+	"media-src":       {"media-src", "default-src"},
+	"font-src":        {"font-src", "default-src"},
+	"img-src":         {"img-src", "default-src"},
+	"child-src":       {"child-src", "default-src"},
+	"script-src":      {"script-src", "default-src"},
+	"style-src":       {"style-src", "default-src"},
+}
+
+// Directive returns the first directive that exists in the fallback list of
+// the directive with the provided name. If none of them exist a directive that
+// allows everything is returned.
 func (p Policy) Directive(name string) Directive {
-	d, ok := p.Directives[name]
-	if ok {
-		return d
+	_, d := p.LookupDirective(name)
+	return d
+}
+
+// LookupDirective returns the name and value of the directive that is used to
+// check the effective directive with the provided name. The returned name is
+// empty if no directive in the policy applies.
+func (p Policy) LookupDirective(name string) (string, Directive) {
+	fallbacks, ok := directiveFallbacks[name]
+	if !ok {
+		fallbacks = []string{name}
+	}
+	for _, fallback := range fallbacks {
+		if d, ok := p.Directives[fallback]; ok {
+			return fallback, d
+		}
 	}
+	return "", AllowDirective{}
+}
 
-	// frame-ancestors defaults to always allow.
-	if name == "frame-ancestors" {
-		return AllowDirective{}
+// check checks ctx against the effective directive with the provided name and
+// returns a report for the first request in its redirect chain that isn't
+// allowed. The report's DirectiveName is the effective directive and its
+// ViolatedDirective is the directive that blocked it, such as default-src.
+func (p Policy) check(name string, ctx SourceContext) ([]Report, error) {
+	for _, hop := range ctx.hops() {
+		r, blocked, err := p.checkHop(name, hop)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return []Report{r}, nil
+		}
 	}
+	return nil, nil
+}
+
+// checkHop checks a single request of a redirect chain and returns a report if
+// it isn't allowed. The requests before it aren't checked.
+func (p Policy) checkHop(name string, hop SourceContext) (Report, bool, error) {
+	directiveName, directive := p.LookupDirective(name)
 
-	d, ok = p.Directives["default-src"]
-	if ok {
-		return d
+	// Block all insecure requests if block-all-mixed-content is set, even if
+	// no directive applies.
+	if p.BlockAllMixedContent && hop.Page.Scheme == "https" && hop.URL.Scheme == "http" {
+		r := p.report(hop, name, directive)
+		r.ViolatedDirective = "block-all-mixed-content"
+		return r, true, nil
 	}
 
-	// If no directives use default policy.
-	g, err := glob.Compile("*://*")
-	if err != nil {
-		panic(err)
+	var allowed bool
+	var err error
+	if s, ok := directive.(SourceDirective); ok {
+		allowed, err = s.checkHop(p, hop)
+	} else {
+		allowed, err = directive.Check(p, hop)
 	}
-	return SourceDirective{
-		Hosts: []glob.Glob{g},
+	if err != nil || allowed {
+		return Report{}, false, err
+	}
+	r := p.report(hop, name, directive)
+	r.ViolatedDirective = directiveName
+	return r, true, nil
+}
+
+// report returns a report of a violation of the policy. OriginalPolicy is the
+// policy as it was parsed, or the serialized policy if it wasn't parsed.
+func (p Policy) report(ctx SourceContext, name string, directive Directive) Report {
+	r := ctx.Report(name, directive)
+	r.Disposition = p.Disposition
+	r.OriginalPolicy = p.original
+	if len(r.OriginalPolicy) == 0 {
+		r.OriginalPolicy = p.String()
 	}
+	return r
 }
@@ -82,6 +82,27 @@ func TestCSP(t *testing.T) {
 			html:   `<script nonce="foo" src="https://www.google.com"></script>`,
 			valid:  true,
 		},
+		{
+			name:      "empty nonce",
+			policy:    "script-src 'nonce-'",
+			page:      "https://google.com",
+			policyErr: "invalid base64 value",
+			valid:     true,
+		},
+		{
+			name:      "malformed hash",
+			policy:    "script-src 'sha256-a!b'",
+			page:      "https://google.com",
+			policyErr: "invalid base64 value",
+			valid:     true,
+		},
+		{
+			name:   "nonce with dashes",
+			policy: "default-src 'nonce-a-b_c='",
+			page:   "https://google.com",
+			html:   `<script nonce="a-b_c=" src="https://www.google.com"></script>`,
+			valid:  true,
+		},
 		{
 			policy: "default-src 'sha256-LCa0a2j/xo/5m0U8HTBBNBNCLXBkg7+g+YpeiGJm564='",
 			page:   "https://google.com",
@@ -253,6 +274,529 @@ func TestCSP(t *testing.T) {
 			html:   ``,
 			valid:  true,
 		},
+		{
+			name:   "meta policy applies to later elements",
+			policy: "default-src *",
+			page:   "https://google.com",
+			html: `<head>
+				<meta http-equiv="Content-Security-Policy" content="script-src 'self'">
+				<script src="https://foo.com/foo.js"></script>
+			</head>`,
+			valid: false,
+		},
+		{
+			name:   "meta policy doesn't apply to earlier elements",
+			policy: "default-src *",
+			page:   "https://google.com",
+			html: `<head>
+				<script src="https://foo.com/foo.js"></script>
+				<meta http-equiv="content-security-policy" content="script-src 'self'">
+				<script src="https://google.com/foo.js"></script>
+			</head>`,
+			valid: true,
+		},
+		{
+			name:   "meta policy ignores sandbox and frame-ancestors",
+			policy: "default-src *",
+			page:   "https://google.com",
+			html: `<head>
+				<meta http-equiv="Content-Security-Policy" content="sandbox; frame-ancestors 'none'; img-src 'self'">
+			</head>
+			<body><img src="https://google.com/foo.png"></body>`,
+			valid: true,
+		},
+		{
+			name:   "meta policy outside head is ignored",
+			policy: "default-src *",
+			page:   "https://google.com",
+			html: `<body>
+				<div><meta http-equiv="Content-Security-Policy" content="img-src 'none'"></div>
+				<img src="https://google.com/foo.png">
+			</body>`,
+			valid: true,
+		},
+		{
+			name:        "invalid meta policy",
+			policy:      "default-src *",
+			page:        "https://google.com",
+			html:        `<meta http-equiv="Content-Security-Policy" content="foo-src 'none'">`,
+			validateErr: "meta policy",
+		},
+		{
+			name:   "strict-dynamic ignores hosts",
+			policy: "script-src 'strict-dynamic' https://google.com",
+			page:   "https://google.com",
+			html:   `<script src="https://google.com/foo.js"></script>`,
+			valid:  false,
+		},
+		{
+			name:   "strict-dynamic ignores self",
+			policy: "script-src 'strict-dynamic' 'self'",
+			page:   "https://google.com",
+			html:   `<script src="https://google.com/foo.js"></script>`,
+			valid:  false,
+		},
+		{
+			name:   "strict-dynamic allows nonces",
+			policy: "script-src 'strict-dynamic' 'nonce-foo' https: 'unsafe-inline'",
+			page:   "https://google.com",
+			html:   `<script nonce="foo" src="https://foo.com/foo.js"></script>`,
+			valid:  true,
+		},
+		{
+			name:   "strict-dynamic ignores unsafe-inline",
+			policy: "script-src 'strict-dynamic' 'unsafe-inline'",
+			page:   "https://google.com",
+			html:   `<script>foo</script>`,
+			valid:  false,
+		},
+		{
+			name:   "strict-dynamic allows hashes",
+			policy: "script-src 'strict-dynamic' 'sha256-LCa0a2j/xo/5m0U8HTBBNBNCLXBkg7+g+YpeiGJm564='",
+			page:   "https://google.com",
+			html:   `<script>foo</script>`,
+			valid:  true,
+		},
+		{
+			name:   "strict-dynamic doesn't apply to other resources",
+			policy: "default-src 'strict-dynamic' 'self'",
+			page:   "https://google.com",
+			html:   `<img src="https://google.com/foo.png">`,
+			valid:  true,
+		},
+		{
+			name:   "event handler blocked",
+			policy: "script-src 'self'",
+			page:   "https://google.com",
+			html:   `<button onclick="alert(1)">foo</button>`,
+			valid:  false,
+		},
+		{
+			name:   "event handler unsafe-inline",
+			policy: "script-src 'self' 'unsafe-inline'",
+			page:   "https://google.com",
+			html:   `<body onload="alert(1)"></body>`,
+			valid:  true,
+		},
+		{
+			name:   "event handler hash requires unsafe-hashes",
+			policy: "script-src 'sha256-bhHHL3z2vDgxUt0W3dWQOrprscmda2Y5pLsLg4GF+pI='",
+			page:   "https://google.com",
+			html:   `<button onclick="alert(1)">foo</button>`,
+			valid:  false,
+		},
+		{
+			name:   "event handler unsafe-hashes",
+			policy: "script-src 'unsafe-hashes' 'sha256-bhHHL3z2vDgxUt0W3dWQOrprscmda2Y5pLsLg4GF+pI='",
+			page:   "https://google.com",
+			html:   `<button onclick="alert(1)">foo</button>`,
+			valid:  true,
+		},
+		{
+			name:   "event handler nonce",
+			policy: "script-src 'nonce-foo'",
+			page:   "https://google.com",
+			html:   `<button nonce="foo" onclick="alert(1)">foo</button>`,
+			valid:  false,
+		},
+		{
+			name:   "javascript: URL blocked",
+			policy: "script-src 'self'",
+			page:   "https://google.com",
+			html:   `<a href="javascript:alert(1)">foo</a>`,
+			valid:  false,
+		},
+		{
+			name:   "javascript: URL unsafe-hashes",
+			policy: "script-src 'unsafe-hashes' 'sha256-0O4EIczpUh2iSZOcxVYyoM7m7DbV9aGUDgxHgAAHoOo='",
+			page:   "https://google.com",
+			html:   `<a href="javascript:alert(1)">foo</a>`,
+			valid:  true,
+		},
+		{
+			name:   "normal links are allowed",
+			policy: "script-src 'self'",
+			page:   "https://google.com",
+			html:   `<a href="https://foo.com">foo</a>`,
+			valid:  true,
+		},
+		{
+			name:   "style attribute blocked",
+			policy: "style-src 'self'",
+			page:   "https://google.com",
+			html:   `<div style="color: red"></div>`,
+			valid:  false,
+		},
+		{
+			name:   "style attribute unsafe-inline",
+			policy: "style-src 'unsafe-inline'",
+			page:   "https://google.com",
+			html:   `<div style="color: red"></div>`,
+			valid:  true,
+		},
+		{
+			name:   "style attribute hash requires unsafe-hashes",
+			policy: "style-src 'sha256-NerDAUWfwD31YdZHveMrq0GLjsNFMwxLpZl0dPUeCcw='",
+			page:   "https://google.com",
+			html:   `<div style="color: red"></div>`,
+			valid:  false,
+		},
+		{
+			name:   "style attribute unsafe-hashes",
+			policy: "style-src 'unsafe-hashes' 'sha256-NerDAUWfwD31YdZHveMrq0GLjsNFMwxLpZl0dPUeCcw='",
+			page:   "https://google.com",
+			html:   `<div style="color: red"></div>`,
+			valid:  true,
+		},
+		{
+			name:   "stylesheet background image blocked",
+			policy: "style-src 'unsafe-inline'; img-src 'self'",
+			page:   "https://google.com",
+			html:   `<style>body { background: url("https://foo.com/bg.png") no-repeat; }</style>`,
+			valid:  false,
+		},
+		{
+			name:   "stylesheet background image allowed",
+			policy: "style-src 'unsafe-inline'; img-src 'self'",
+			page:   "https://google.com",
+			html:   `<style>body { background-image: url(/bg.png); cursor: url('/foo.cur'), auto; }</style>`,
+			valid:  true,
+		},
+		{
+			name:   "stylesheet image-set",
+			policy: "style-src 'unsafe-inline'; img-src 'self'",
+			page:   "https://google.com",
+			html:   `<style>div { background-image: image-set("/a.png" 1x, "https://foo.com/b.png" 2x); }</style>`,
+			valid:  false,
+		},
+		{
+			name:   "stylesheet nested media rules",
+			policy: "style-src 'unsafe-inline'; img-src 'self'",
+			page:   "https://google.com",
+			html: `<style>
+				@media screen {
+					@supports (display: grid) {
+						div { list-style-image: url(https://foo.com/dot.png); }
+					}
+				}
+			</style>`,
+			valid: false,
+		},
+		{
+			name:   "style attribute terminated",
+			policy: "style-src 'unsafe-inline'; img-src 'self'",
+			page:   "https://google.com",
+			html:   `<div style="background-image: url(/bg.png);"></div>`,
+			valid:  true,
+		},
+		{
+			name:   "stylesheet fragment references",
+			policy: "style-src 'unsafe-inline'; img-src 'none'",
+			page:   "https://google.com",
+			html:   `<style>div { mask: url(#mask); }</style>`,
+			valid:  true,
+		},
+		{
+			name:   "style attribute background image",
+			policy: "style-src 'unsafe-inline'; img-src 'self'",
+			page:   "https://google.com",
+			html:   `<div style="color: red; background-image: url(https://foo.com/bg.png)"></div>`,
+			valid:  false,
+		},
+		{
+			name:   "script-src-elem overrides script-src",
+			policy: "script-src 'none'; script-src-elem https://foo.com",
+			page:   "https://google.com",
+			html:   `<script src="https://foo.com/foo.js"></script>`,
+			valid:  true,
+		},
+		{
+			name:   "script-src-elem doesn't apply to event handlers",
+			policy: "script-src 'unsafe-inline'; script-src-elem 'self'",
+			page:   "https://google.com",
+			html:   `<button onclick="alert(1)"></button>`,
+			valid:  true,
+		},
+		{
+			name:   "script-src-attr blocks event handlers",
+			policy: "script-src 'unsafe-inline'; script-src-attr 'none'",
+			page:   "https://google.com",
+			html:   `<script>foo</script><button onclick="alert(1)"></button>`,
+			valid:  false,
+		},
+		{
+			name:   "script-src-attr falls back to default-src",
+			policy: "default-src 'self'",
+			page:   "https://google.com",
+			html:   `<button onclick="alert(1)"></button>`,
+			valid:  false,
+		},
+		{
+			name:   "style-src-elem allows link stylesheets",
+			policy: "style-src 'none'; style-src-elem https://foo.com",
+			page:   "https://google.com",
+			html:   `<link rel="stylesheet" href="https://foo.com/style.css">`,
+			valid:  true,
+		},
+		{
+			name:   "style-src-attr allows style attributes",
+			policy: "style-src 'none'; style-src-attr 'unsafe-inline'",
+			page:   "https://google.com",
+			html:   `<div style="color: red"></div>`,
+			valid:  true,
+		},
+		{
+			name:   "style-src-attr doesn't apply to style elements",
+			policy: "style-src 'none'; style-src-attr 'unsafe-inline'",
+			page:   "https://google.com",
+			html:   `<style>div { color: red; }</style>`,
+			valid:  false,
+		},
+		{
+			name:   "frame-src falls back to child-src",
+			policy: "default-src *; child-src 'self'",
+			page:   "https://google.com",
+			html:   `<iframe src="https://foo.com"></iframe>`,
+			valid:  false,
+		},
+		{
+			name:   "base-uri doesn't fall back to default-src",
+			policy: "default-src 'none'",
+			page:   "https://google.com",
+			html:   `<base href="https://foo.com/">`,
+			valid:  true,
+		},
+		{
+			name:   "inline scripts allowed without applicable directive",
+			policy: "img-src 'none'",
+			page:   "https://google.com",
+			html:   `<script>foo</script><button onclick="foo()"></button>`,
+			valid:  true,
+		},
+		{
+			name:   "sandbox blocks scripts",
+			policy: "sandbox",
+			page:   "https://google.com",
+			html:   `<script src="https://google.com/foo.js"></script>`,
+			valid:  false,
+		},
+		{
+			name:   "sandbox blocks event handlers",
+			policy: "sandbox allow-same-origin",
+			page:   "https://google.com",
+			html:   `<button onclick="foo()"></button>`,
+			valid:  false,
+		},
+		{
+			name:   "sandbox allow-scripts",
+			policy: "sandbox allow-scripts",
+			page:   "https://google.com",
+			html:   `<script>foo</script><a href="javascript:foo()"></a>`,
+			valid:  true,
+		},
+		{
+			name:   "sandbox blocks forms",
+			policy: "sandbox allow-scripts",
+			page:   "https://google.com",
+			html:   `<form action="/submit"></form>`,
+			valid:  false,
+		},
+		{
+			name:   "sandbox allow-forms",
+			policy: "sandbox allow-forms",
+			page:   "https://google.com",
+			html:   `<form action="/submit"></form>`,
+			valid:  true,
+		},
+		{
+			name:   "sandbox opaque origin doesn't match 'self'",
+			policy: "sandbox allow-scripts; img-src 'self'",
+			page:   "https://google.com",
+			html:   `<img src="/foo.png">`,
+			valid:  false,
+		},
+		{
+			name:   "sandbox allow-same-origin matches 'self'",
+			policy: "sandbox allow-scripts allow-same-origin; img-src 'self'",
+			page:   "https://google.com",
+			html:   `<img src="/foo.png">`,
+			valid:  true,
+		},
+		{
+			name:   "sandbox ignores unknown flags",
+			policy: "sandbox allow-everything allow-forms",
+			page:   "https://google.com",
+			html:   `<form action="/submit"></form><script>foo</script>`,
+			valid:  false,
+		},
+		{
+			name:   "form-action allows same origin login",
+			policy: "form-action 'self'",
+			page:   "https://google.com/login",
+			html:   `<form action="/login" method="post"><input type="submit"></form>`,
+			valid:  true,
+		},
+		{
+			name:   "form-action blocks third-party IdP",
+			policy: "form-action 'self'",
+			page:   "https://google.com/login",
+			html:   `<form action="https://idp.com/sso" method="post"></form>`,
+			valid:  false,
+		},
+		{
+			name:   "form-action allows listed IdP",
+			policy: "form-action 'self' https://idp.com",
+			page:   "https://google.com/login",
+			html:   `<form action="https://idp.com/sso" method="post"></form>`,
+			valid:  true,
+		},
+		{
+			name:   "form-action empty action submits to page",
+			policy: "form-action 'none'",
+			page:   "https://google.com/login",
+			html:   `<form></form>`,
+			valid:  false,
+		},
+		{
+			name:   "form-action doesn't fall back to default-src",
+			policy: "default-src 'none'",
+			page:   "https://google.com/login",
+			html:   `<form action="https://idp.com/sso"></form>`,
+			valid:  true,
+		},
+		{
+			name:   "form-action checks button formaction",
+			policy: "form-action 'self'",
+			page:   "https://google.com/login",
+			html:   `<form action="/login"><button formaction="https://idp.com/sso">SSO</button></form>`,
+			valid:  false,
+		},
+		{
+			name:   "form-action checks input formaction",
+			policy: "form-action 'self'",
+			page:   "https://google.com/login",
+			html:   `<form action="/login"><input type="image" formaction="https://idp.com/sso"></form>`,
+			valid:  false,
+		},
+		{
+			name:   "form-action ignores formaction of non-submit buttons",
+			policy: "form-action 'self'",
+			page:   "https://google.com/login",
+			html:   `<form action="/login"><button type="button" formaction="https://idp.com/sso"></button><input type="text" formaction="https://idp.com/sso"></form>`,
+			valid:  true,
+		},
+		{
+			name:   "form-action ignores formaction of inputs without a type",
+			policy: "form-action 'self'",
+			page:   "https://google.com/login",
+			html:   `<form action="/login"><input formaction="https://idp.com/sso"></form>`,
+			valid:  true,
+		},
+		{
+			name:   "form-action blocks mixed content",
+			policy: "form-action 'self' https:; block-all-mixed-content",
+			page:   "https://google.com/login",
+			html:   `<form action="http://idp.com/sso"></form>`,
+			valid:  false,
+		},
+		{
+			name:   "form-action upgrades insecure requests",
+			policy: "form-action https://idp.com; upgrade-insecure-requests",
+			page:   "https://google.com/login",
+			html:   `<form action="http://idp.com/sso"></form>`,
+			valid:  true,
+		},
+		{
+			name:   "form-action doesn't upgrade without upgrade-insecure-requests",
+			policy: "form-action https://idp.com",
+			page:   "https://google.com/login",
+			html:   `<form action="http://idp.com/sso"></form>`,
+			valid:  false,
+		},
+		{
+			name:   "integrity matching hash allows external script",
+			policy: "script-src 'sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC'",
+			page:   "https://google.com",
+			html:   `<script src="https://cdn.com/foo.js" integrity="sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC" crossorigin="anonymous"></script>`,
+			valid:  true,
+		},
+		{
+			name:   "integrity with strict-dynamic",
+			policy: "script-src 'strict-dynamic' 'sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC'",
+			page:   "https://google.com",
+			html:   `<script src="https://cdn.com/foo.js" integrity="sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC"></script>`,
+			valid:  true,
+		},
+		{
+			name:   "integrity not matching hash",
+			policy: "script-src 'sha384-foo'",
+			page:   "https://google.com",
+			html:   `<script src="https://cdn.com/foo.js" integrity="sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC"></script>`,
+			valid:  false,
+		},
+		{
+			name:   "integrity requires all hashes to match",
+			policy: "script-src 'sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC'",
+			page:   "https://google.com",
+			html:   `<script src="https://cdn.com/foo.js" integrity="sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC sha512-foo"></script>`,
+			valid:  false,
+		},
+		{
+			name:   "integrity doesn't allow stylesheets",
+			policy: "style-src 'sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC'",
+			page:   "https://google.com",
+			html:   `<link rel="stylesheet" href="https://cdn.com/foo.css" integrity="sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC">`,
+			valid:  false,
+		},
+		{
+			name:   "require-sri-for script",
+			policy: "require-sri-for script",
+			page:   "https://google.com",
+			html:   `<script src="https://cdn.com/foo.js"></script>`,
+			valid:  false,
+		},
+		{
+			name:   "require-sri-for script with integrity",
+			policy: "require-sri-for script",
+			page:   "https://google.com",
+			html:   `<script src="https://cdn.com/foo.js" integrity="sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC"></script><script>foo</script><link rel="stylesheet" href="https://cdn.com/foo.css">`,
+			valid:  true,
+		},
+		{
+			name:   "require-sri-for style",
+			policy: "require-sri-for script style",
+			page:   "https://google.com",
+			html:   `<link rel="stylesheet" href="https://cdn.com/foo.css">`,
+			valid:  false,
+		},
+		{
+			name:   "require-sri-for style with integrity",
+			policy: "require-sri-for style",
+			page:   "https://google.com",
+			html:   `<link rel="stylesheet" href="https://cdn.com/foo.css" integrity="sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC"><style>a {}</style>`,
+			valid:  true,
+		},
+		{
+			name:      "require-sri-for unknown type",
+			policy:    "require-sri-for img",
+			page:      "https://google.com",
+			policyErr: "unknown require-sri-for type",
+			valid:     true,
+		},
+		{
+			name:   "host source path scopes widget",
+			policy: "script-src https://widgets.com/chat/",
+			page:   "https://google.com",
+			html:   `<script src="https://widgets.com/chat/loader.js"></script>`,
+			valid:  true,
+		},
+		{
+			name:   "host source path blocks other paths",
+			policy: "script-src https://widgets.com/chat/",
+			page:   "https://google.com",
+			html:   `<script src="https://widgets.com/ads/loader.js"></script>`,
+			valid:  false,
+		},
 		{
 			name:   "default policy allows everything",
 			policy: "font-src 'none'",
@@ -287,3 +831,179 @@ func TestCSP(t *testing.T) {
 		})
 	}
 }
+
+func TestLookupDirective(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		policy, name, want string
+	}{
+		{"default-src 'self'", "script-src-elem", "default-src"},
+		{"default-src 'self'; script-src 'self'", "script-src-elem", "script-src"},
+		{"default-src 'self'; script-src 'self'", "script-src-attr", "script-src"},
+		{"default-src 'self'; style-src 'self'", "style-src-attr", "style-src"},
+		{"default-src 'self'; child-src 'self'", "frame-src", "child-src"},
+		{"default-src 'self'; child-src 'self'", "worker-src", "child-src"},
+		{"default-src 'self'; script-src 'self'", "worker-src", "script-src"},
+		{"default-src 'self'; worker-src 'self'", "worker-src", "worker-src"},
+		{"default-src 'self'", "base-uri", ""},
+		{"default-src 'self'", "form-action", ""},
+		{"default-src 'self'", "frame-ancestors", ""},
+		{"img-src 'self'", "font-src", ""},
+	}
+
+	for i, c := range cases {
+		p, err := ParsePolicy(c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, d := p.LookupDirective(c.name)
+		if got != c.want {
+			t.Errorf("%d. LookupDirective(%q) = %q; not %q", i, c.name, got, c.want)
+		}
+		if _, ok := d.(AllowDirective); ok != (c.want == "") {
+			t.Errorf("%d. LookupDirective(%q) = %+v; expected AllowDirective only if no directive applies", i, c.name, d)
+		}
+	}
+}
+
+func TestReportDirectiveNames(t *testing.T) {
+	t.Parallel()
+
+	page, err := url.Parse("https://google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		policy, html                   string
+		directive, violated, effective string
+	}{
+		{
+			"default-src 'self'",
+			`<script src="https://foo.com/foo.js"></script>`,
+			"script-src-elem", "default-src", "script-src-elem",
+		},
+		{
+			"img-src http:; block-all-mixed-content",
+			`<img src="http://foo.com/foo.png">`,
+			"img-src", "block-all-mixed-content", "img-src",
+		},
+	}
+
+	for i, c := range cases {
+		p, err := ParsePolicy(c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, reports, err := ValidatePage(p, *page, strings.NewReader(c.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 1 {
+			t.Fatalf("%d. expected 1 report; got %+v", i, reports)
+		}
+		r := reports[0]
+		if r.DirectiveName != c.directive || r.ViolatedDirective != c.violated || r.EffectiveDirective != c.effective {
+			t.Errorf("%d. report directives = %q, %q, %q; not %q, %q, %q", i, r.DirectiveName, r.ViolatedDirective, r.EffectiveDirective, c.directive, c.violated, c.effective)
+		}
+	}
+}
+
+func TestPolicyString(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		policy, want string
+	}{
+		{"default-src 'self'", "default-src 'self'"},
+		{
+			"script-src https://cdn.com 'nonce-b' 'self' 'nonce-a' https: 'strict-dynamic'; default-src 'none'",
+			"default-src 'none'; script-src 'self' 'strict-dynamic' 'nonce-a' 'nonce-b' https: https://cdn.com",
+		},
+		{
+			"style-src 'sha256-LCa0a2j/xo/5m0U8HTBBNBNCLXBkg7+g+YpeiGJm564=' 'unsafe-hashes' 'report-sample'",
+			"style-src 'unsafe-hashes' 'report-sample' 'sha256-LCa0a2j/xo/5m0U8HTBBNBNCLXBkg7+g+YpeiGJm564='",
+		},
+		{
+			"upgrade-insecure-requests; img-src *.google.com data: blob:; block-all-mixed-content",
+			"block-all-mixed-content; img-src blob: data: *.google.com; upgrade-insecure-requests",
+		},
+		{"default-src", "default-src"},
+		{
+			"report-to csp; default-src 'self'; report-uri /a https://b.com/b",
+			"default-src 'self'; report-to csp; report-uri /a https://b.com/b",
+		},
+		{
+			"script-src https://b.com 'sha256-b' https://a.com 'sha256-a' https://b.com 'sha256-b' https: https:",
+			"script-src 'sha256-a' 'sha256-b' https: https://a.com https://b.com",
+		},
+		{"sandbox allow-scripts allow-forms", "sandbox allow-forms allow-scripts"},
+		{"sandbox", "sandbox"},
+		{"require-sri-for style script", "require-sri-for script style"},
+	}
+
+	for i, c := range cases {
+		p, err := ParsePolicy(c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.String(); got != c.want {
+			t.Errorf("%d. ParsePolicy(%q).String() = %q; not %q", i, c.policy, got, c.want)
+		}
+
+		text, err := p.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p2 Policy
+		if err := p2.UnmarshalText(text); err != nil {
+			t.Fatal(err)
+		}
+		if got := p2.String(); got != c.want {
+			t.Errorf("%d. round trip of %q = %q; not %q", i, c.policy, got, c.want)
+		}
+	}
+}
+
+func TestRedirectChain(t *testing.T) {
+	t.Parallel()
+
+	p, err := ParsePolicy("script-src https://cdn.com https://mirror.com; img-src https:; block-all-mixed-content")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		directive string
+		chain     []string
+		blocked   string
+	}{
+		{"script-src-elem", []string{"https://cdn.com/a.js"}, ""},
+		{"script-src-elem", []string{"https://cdn.com/a.js", "https://mirror.com/a.js"}, ""},
+		{"script-src-elem", []string{"https://cdn.com/a.js", "https://evil.com/a.js"}, "https://evil.com/a.js"},
+		{"script-src-elem", []string{"https://cdn.com/a.js", "https://evil.com/a.js", "https://mirror.com/a.js"}, "https://evil.com/a.js"},
+		{"script-src-elem", []string{"https://evil.com/a.js", "https://cdn.com/a.js"}, "https://evil.com/a.js"},
+		{"img-src", []string{"https://cdn.com/a.png", "http://cdn.com/a.png", "https://cdn.com/b.png"}, "http://cdn.com/a.png"},
+	}
+
+	for i, c := range cases {
+		urls := parseURLs(t, append([]string{"https://example.com"}, c.chain...)...)
+		ctx := SourceContext{
+			Page:      urls[0],
+			URL:       urls[len(urls)-1],
+			Redirects: urls[1 : len(urls)-1],
+		}
+		reports, err := p.check(c.directive, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var blocked string
+		if len(reports) > 0 {
+			blocked = reports[0].Blocked
+		}
+		if blocked != c.blocked {
+			t.Errorf("%d. check(%q) blocked %q; not %q", i, c.chain, blocked, c.blocked)
+		}
+	}
+}
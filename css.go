@@ -2,8 +2,10 @@ package csp
 
 import (
 	"net/url"
+	"regexp"
 	"strings"
 
+	"github.com/aymerick/douceur/css"
 	"github.com/aymerick/douceur/parser"
 	"github.com/pkg/errors"
 )
@@ -28,49 +30,102 @@ func parseCSSURL(s string) (string, error) {
 	return "", errors.Errorf("invalid URL or string: %q", s)
 }
 
-// ValidateStylesheet validates a stylesheet for CSP violations from imports and
-// font-face sources.
+// ValidateStylesheet validates a stylesheet for CSP violations from imports,
+// font-face sources and images referenced by declarations.
 func ValidateStylesheet(p Policy, page url.URL, css string) (bool, []Report, error) {
-	stylesheet, err := parser.Parse(css)
+	return PolicySet{p}.ValidateStylesheet(page, css)
+}
+
+// validateStyleAttribute checks the declarations of an inline style attribute
+// the same way as a stylesheet.
+func validateStyleAttribute(p Policy, page url.URL, style string) ([]Report, error) {
+	// The parser drops the value of the last declaration if it isn't
+	// terminated.
+	style = strings.TrimSpace(style)
+	if len(style) > 0 && !strings.HasSuffix(style, ";") {
+		style += ";"
+	}
+	declarations, err := parser.ParseDeclarations(style)
 	if err != nil {
-		return false, nil, err
+		return nil, err
 	}
+	rule := css.NewRule(css.QualifiedRule)
+	rule.Declarations = declarations
+	return validateStylesheet(p, page, page, &css.Stylesheet{
+		Rules: []*css.Rule{rule},
+	}, style)
+}
+
+// validateStylesheet returns all the violations of a single policy in
+// stylesheet. Relative URLs are resolved against base, which is the page for
+// inline styles. source is the text stylesheet was parsed from and is used to
+// locate the violations.
+func validateStylesheet(p Policy, page, base url.URL, stylesheet *css.Stylesheet, source string) ([]Report, error) {
+	l := &cssLocator{lines: newLineIndex(source)}
+	return validateRules(p, page, base, l, "", stylesheet.Rules)
+}
+
+// cssLocator finds the positions of URLs in a stylesheet. The parser doesn't
+// keep track of positions so the URLs are searched for in the source. Rules
+// are checked in source order, so each search starts after the last match.
+type cssLocator struct {
+	lines lineIndex
+	next  int
+}
 
-	directiveName := "style-src"
-	directive := p.Directive(directiveName)
+// locate sets the position of reports to the position of ref.
+func (l *cssLocator) locate(reports []Report, ref, path string) {
+	source := l.lines.source
+	var pos Position
+	if i := strings.Index(source[l.next:], ref); i >= 0 {
+		pos = l.lines.position(l.next + i)
+		l.next += i + len(ref)
+	} else if i := strings.Index(source, ref); i >= 0 {
+		pos = l.lines.position(i)
+	}
+	pos.Path = path
+	setPosition(reports, pos)
+}
 
-	directiveFontName := "font-src"
-	directiveFont := p.Directive(directiveFontName)
+// rulePath returns the path of a rule nested in the rule with path parent.
+// Qualified rules are identified by their selectors and at-rules by their name
+// and, if they contain other rules, their prelude such as "@media screen".
+func rulePath(parent string, rule *css.Rule) string {
+	name := rule.Prelude
+	if rule.Kind == css.AtRule {
+		name = rule.Name
+		if rule.EmbedsRules() {
+			name += " " + rule.Prelude
+		}
+	}
+	name = strings.TrimSpace(name)
+	if len(parent) == 0 {
+		return name
+	}
+	return parent + " > " + name
+}
 
+// validateRules checks the rules of a stylesheet and any rules nested inside
+// of them.
+func validateRules(p Policy, page, base url.URL, l *cssLocator, parent string, rules []*css.Rule) ([]Report, error) {
 	var reports []Report
-	for _, rule := range stylesheet.Rules {
+	for _, rule := range rules {
+		path := rulePath(parent, rule)
 		if rule.Name == "@import" {
 			parts := strings.Fields(rule.Prelude)
 			if len(parts) == 0 {
-				return false, nil, errors.Errorf("@import empty")
+				return nil, errors.Errorf("@import empty")
 			}
 			imp, err := parseCSSURL(parts[0])
 			if err != nil {
-				return false, nil, err
+				return nil, err
 			}
-
-			ctx := SourceContext{
-				Page: page,
-			}
-			parsed, err := url.Parse(imp)
+			importReports, err := checkStylesheetURL(p, page, base, "style-src-elem", imp)
 			if err != nil {
-				return false, nil, err
-			}
-
-			ctx.URL = *page.ResolveReference(parsed)
-
-			v, err := directive.Check(p, ctx)
-			if err != nil {
-				return false, nil, err
-			}
-			if !v {
-				reports = append(reports, ctx.Report(directiveName, directive))
+				return nil, err
 			}
+			l.locate(importReports, imp, path)
+			reports = append(reports, importReports...)
 		} else if rule.Name == "@font-face" {
 			for _, decl := range rule.Declarations {
 				if decl.Property != "src" {
@@ -86,29 +141,94 @@ func ValidateStylesheet(p Policy, page url.URL, css string) (bool, []Report, err
 					fields := strings.Fields(part)
 					imp, err := parseCSSURL(fields[0])
 					if err != nil {
-						return false, nil, err
+						return nil, err
 					}
-
-					ctx := SourceContext{
-						Page: page,
-					}
-					parsed, err := url.Parse(imp)
+					fontReports, err := checkStylesheetURL(p, page, base, "font-src", imp)
 					if err != nil {
-						return false, nil, err
+						return nil, err
 					}
+					l.locate(fontReports, imp, path)
+					reports = append(reports, fontReports...)
+				}
+			}
+		} else if rule.EmbedsRules() {
+			nestedReports, err := validateRules(p, page, base, l, path, rule.Rules)
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, nestedReports...)
+		} else {
+			declReports, err := validateDeclarations(p, page, base, l, path, rule.Declarations)
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, declReports...)
+		}
+	}
+	return reports, nil
+}
 
-					ctx.URL = *page.ResolveReference(parsed)
+// validateDeclarations checks the images referenced by url() and image-set()
+// in declarations such as background-image and cursor.
+func validateDeclarations(p Policy, page, base url.URL, l *cssLocator, path string, declarations []*css.Declaration) ([]Report, error) {
+	var reports []Report
+	for _, decl := range declarations {
+		for _, ref := range declarationURLs(decl.Value) {
+			imgReports, err := checkStylesheetURL(p, page, base, "img-src", ref)
+			if err != nil {
+				return nil, err
+			}
+			l.locate(imgReports, ref, path)
+			reports = append(reports, imgReports...)
+		}
+	}
+	return reports, nil
+}
 
-					v, err := directiveFont.Check(p, ctx)
-					if err != nil {
-						return false, nil, err
-					}
-					if !v {
-						reports = append(reports, ctx.Report(directiveFontName, directiveFont))
-					}
-				}
+var (
+	cssURLRegex      = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^"'()\s]*))\s*\)`)
+	cssImageSetRegex = regexp.MustCompile(`(?i)image-set\(((?:[^()]|\([^()]*\))*)\)`)
+	cssStringRegex   = regexp.MustCompile(`"([^"]*)"|'([^']*)'`)
+)
+
+// declarationURLs returns all the URLs referenced by a declaration value.
+// Fragment only references such as url(#mask) point into the current document
+// and are skipped.
+func declarationURLs(value string) []string {
+	var refs []string
+	add := func(matches []string) {
+		for _, m := range matches[1:] {
+			if len(m) > 0 && !strings.HasPrefix(m, "#") {
+				refs = append(refs, m)
+				return
 			}
 		}
 	}
-	return len(reports) == 0, reports, nil
+	for _, m := range cssURLRegex.FindAllStringSubmatch(value, -1) {
+		add(m)
+	}
+	// image-set() also accepts plain strings as URLs.
+	for _, set := range cssImageSetRegex.FindAllStringSubmatch(value, -1) {
+		args := cssURLRegex.ReplaceAllString(set[1], "")
+		for _, m := range cssStringRegex.FindAllStringSubmatch(args, -1) {
+			add(m)
+		}
+	}
+	return refs
+}
+
+// checkStylesheetURL checks a URL referenced by a stylesheet against the
+// directive with the specified name.
+func checkStylesheetURL(p Policy, page, base url.URL, directiveName, ref string) ([]Report, error) {
+	ctx := SourceContext{
+		Page: page,
+	}
+	parsed, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.URL = *base.ResolveReference(parsed)
+
+	return p.check(directiveName, ctx)
 }
@@ -0,0 +1,132 @@
+package csp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseReportingEndpoints parses a Reporting-Endpoints header value such as
+// `csp="https://example.com/csp", default="/reports"` into a map from endpoint
+// name to URL.
+func ParseReportingEndpoints(header string) (map[string]string, error) {
+	endpoints := map[string]string{}
+	for _, member := range splitQuoted(header, ',') {
+		member = strings.TrimSpace(member)
+		if len(member) == 0 {
+			continue
+		}
+		// Parameters after the value aren't used.
+		member = splitQuoted(member, ';')[0]
+		parts := strings.SplitN(member, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid endpoint %q", member)
+		}
+		name := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if len(name) == 0 || len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+			return nil, errors.Errorf("invalid endpoint %q", member)
+		}
+		endpoints[name] = strings.Replace(value[1:len(value)-1], `\"`, `"`, -1)
+	}
+	return endpoints, nil
+}
+
+// splitQuoted splits s on sep, ignoring separators inside of double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	var quoted, escaped bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// reportToGroup is an endpoint group in a Report-To header.
+type reportToGroup struct {
+	Group     string `json:"group"`
+	Endpoints []struct {
+		URL string `json:"url"`
+	} `json:"endpoints"`
+}
+
+// ParseReportTo parses a Report-To header value into a map from endpoint group
+// name to the URLs of the endpoints in the group. Groups without a name are
+// named "default".
+func ParseReportTo(header string) (map[string][]string, error) {
+	var groups []reportToGroup
+	if err := json.Unmarshal([]byte("["+header+"]"), &groups); err != nil {
+		return nil, errors.Wrap(err, "parsing Report-To")
+	}
+	endpoints := map[string][]string{}
+	for _, group := range groups {
+		name := group.Group
+		if len(name) == 0 {
+			name = "default"
+		}
+		for _, endpoint := range group.Endpoints {
+			endpoints[name] = append(endpoints[name], endpoint.URL)
+		}
+	}
+	return endpoints, nil
+}
+
+// ParseReportingHeaders returns the endpoints declared by the
+// Reporting-Endpoints and Report-To headers in h. Reporting-Endpoints takes
+// precedence if a name is in both.
+func ParseReportingHeaders(h http.Header) (map[string][]string, error) {
+	endpoints := map[string][]string{}
+	for _, header := range h["Report-To"] {
+		groups, err := ParseReportTo(header)
+		if err != nil {
+			return nil, err
+		}
+		for name, urls := range groups {
+			endpoints[name] = append(endpoints[name], urls...)
+		}
+	}
+	for _, header := range h["Reporting-Endpoints"] {
+		named, err := ParseReportingEndpoints(header)
+		if err != nil {
+			return nil, err
+		}
+		for name, u := range named {
+			endpoints[name] = []string{u}
+		}
+	}
+	return endpoints, nil
+}
+
+// ReportEndpoints returns the URLs that violations of the policy are sent to
+// when it's applied to page. As in browsers, the report-to group is used if it
+// has endpoints and report-uri is used otherwise. endpoints is a map from group
+// name to URLs, such as returned by ParseReportingHeaders.
+func (p Policy) ReportEndpoints(page url.URL, endpoints map[string][]string) ([]url.URL, error) {
+	uris := p.ReportURIs
+	if group := endpoints[p.ReportTo]; len(p.ReportTo) > 0 && len(group) > 0 {
+		uris = group
+	}
+	var resolved []url.URL
+	for _, uri := range uris {
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, *page.ResolveReference(parsed))
+	}
+	return resolved, nil
+}
@@ -0,0 +1,106 @@
+package csp
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseReportingEndpoints(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		header string
+		want   map[string]string
+		err    string
+	}{
+		{
+			`csp="https://example.com/csp", default="/reports"`,
+			map[string]string{"csp": "https://example.com/csp", "default": "/reports"},
+			"",
+		},
+		{
+			`csp="https://example.com/a,b";foo=bar`,
+			map[string]string{"csp": "https://example.com/a,b"},
+			"",
+		},
+		{``, map[string]string{}, ""},
+		{`csp=https://example.com`, nil, "invalid endpoint"},
+		{`csp`, nil, "invalid endpoint"},
+	}
+
+	for i, c := range cases {
+		got, err := ParseReportingEndpoints(c.header)
+		checkErr(t, err, c.err)
+		if err == nil && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%d. ParseReportingEndpoints(%q) = %+v; not %+v", i, c.header, got, c.want)
+		}
+	}
+}
+
+func TestParseReportTo(t *testing.T) {
+	t.Parallel()
+
+	header := `{"group":"csp","max_age":10886400,"endpoints":[{"url":"https://a.com/csp"},{"url":"https://b.com/csp"}]}, {"max_age":1,"endpoints":[{"url":"/reports"}]}`
+	got, err := ParseReportTo(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"csp":     {"https://a.com/csp", "https://b.com/csp"},
+		"default": {"/reports"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseReportTo(%q) = %+v; not %+v", header, got, want)
+	}
+
+	if _, err := ParseReportTo("{"); err == nil {
+		t.Errorf("expected error parsing invalid Report-To")
+	}
+}
+
+func TestReportEndpoints(t *testing.T) {
+	t.Parallel()
+
+	h := http.Header{}
+	h.Set("Report-To", `{"group":"csp","endpoints":[{"url":"https://old.com/csp"}]}, {"group":"other","endpoints":[{"url":"https://other.com"}]}`)
+	h.Set("Reporting-Endpoints", `csp="/csp-reports"`)
+	endpoints, err := ParseReportingHeaders(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := url.Parse("https://example.com/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		policy string
+		want   []string
+	}{
+		{"default-src 'self'; report-uri /a https://b.com/b", []string{"https://example.com/a", "https://b.com/b"}},
+		{"default-src 'self'; report-uri /a; report-to csp", []string{"https://example.com/csp-reports"}},
+		{"default-src 'self'; report-to other", []string{"https://other.com"}},
+		{"default-src 'self'; report-uri /a; report-to missing", []string{"https://example.com/a"}},
+		{"default-src 'self'", nil},
+	}
+
+	for i, c := range cases {
+		p, err := ParsePolicy(c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls, err := p.ReportEndpoints(*page, endpoints)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, u := range urls {
+			got = append(got, u.String())
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%d. ReportEndpoints(%q) = %q; not %q", i, c.policy, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package csp
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/aymerick/douceur/css"
+	"github.com/aymerick/douceur/parser"
+	"github.com/pkg/errors"
+)
+
+// maxFetchSize is the maximum size of a resource fetched by HTTPFetcher.
+const maxFetchSize = 10 << 20
+
+// ErrNotFound is returned by Fetchers for resources that don't exist. Validator
+// skips them instead of failing.
+var ErrNotFound = errors.New("resource not found")
+
+// Resource is a resource loaded by a Fetcher.
+type Resource struct {
+	// URL is the URL the resource was loaded from.
+	URL url.URL
+	// Redirects are the URLs that redirected to URL, starting with the
+	// requested URL.
+	Redirects []url.URL
+	Body      []byte
+}
+
+// Fetcher loads the resources referenced by a page so they can be checked for
+// violations too.
+type Fetcher interface {
+	Fetch(u url.URL) (Resource, error)
+}
+
+// HTTPFetcher fetches resources over HTTP with Client or http.DefaultClient if
+// it's nil.
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+// Fetch implements Fetcher. Redirects are followed by the client and recorded
+// in the resource. 404 and 410 responses return ErrNotFound, and any other
+// response that isn't 2xx or that is larger than 10MiB is an error.
+func (f HTTPFetcher) Fetch(u url.URL) (Resource, error) {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return Resource{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return Resource{}, errors.Wrapf(ErrNotFound, "fetching %s", u.String())
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return Resource{}, errors.Errorf("fetching %s: %s", u.String(), resp.Status)
+	}
+	// Read one byte past the limit so truncated bodies aren't checked.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFetchSize+1))
+	if err != nil {
+		return Resource{}, err
+	}
+	if len(body) > maxFetchSize {
+		return Resource{}, errors.Errorf("fetching %s: body larger than %d bytes", u.String(), maxFetchSize)
+	}
+	var redirects []url.URL
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		redirects = append([]url.URL{*r.Request.URL}, redirects...)
+	}
+	return Resource{URL: *resp.Request.URL, Redirects: redirects, Body: body}, nil
+}
+
+// MapFetcher is an in-memory Fetcher that maps URLs to their bodies.
+type MapFetcher map[string]string
+
+// Fetch implements Fetcher.
+func (f MapFetcher) Fetch(u url.URL) (Resource, error) {
+	body, ok := f[u.String()]
+	if !ok {
+		return Resource{}, errors.Wrapf(ErrNotFound, "fetching %s", u.String())
+	}
+	return Resource{URL: u, Body: []byte(body)}, nil
+}
+
+// DirFetcher reads resources from the files in Dir. URLs are mapped to files by
+// their path relative to Base, so with the Base https://example.com/static/
+// the URL https://example.com/static/css/app.css is read from
+// Dir/css/app.css. URLs outside of Base can't be fetched.
+type DirFetcher struct {
+	Dir  string
+	Base url.URL
+}
+
+// Fetch implements Fetcher.
+func (f DirFetcher) Fetch(u url.URL) (Resource, error) {
+	basePath := f.Base.Path
+	if !strings.HasSuffix(basePath, "/") {
+		basePath += "/"
+	}
+	if u.Scheme != f.Base.Scheme || u.Host != f.Base.Host || !strings.HasPrefix(u.Path, basePath) {
+		return Resource{}, errors.Wrapf(ErrNotFound, "fetching %s: not under %s", u.String(), f.Base.String())
+	}
+	rel := path.Clean("/" + strings.TrimPrefix(u.Path, basePath))
+	body, err := ioutil.ReadFile(filepath.Join(f.Dir, filepath.FromSlash(rel)))
+	if os.IsNotExist(err) {
+		return Resource{}, errors.Wrapf(ErrNotFound, "fetching %s", u.String())
+	} else if err != nil {
+		return Resource{}, err
+	}
+	return Resource{URL: u, Body: body}, nil
+}
+
+// Validator validates pages like PolicySet.ValidatePage. If Fetcher is set, it
+// also loads the stylesheets that the policies allow and checks the resources
+// they reference, following @import chains. External scripts and stylesheets
+// with integrity metadata are fetched to check that they match it, as browsers
+// don't use them otherwise. Resources that aren't found are skipped, but other
+// errors from Fetcher fail the validation.
+type Validator struct {
+	Policies PolicySet
+	Fetcher  Fetcher
+}
+
+// ValidatePage checks an HTML page and the resources it references.
+// Violations in fetched stylesheets have their SourceFile set.
+func (v Validator) ValidatePage(page url.URL, html io.Reader) (bool, []Report, error) {
+	doc, err := newDocument(html)
+	if err != nil {
+		return false, nil, err
+	}
+	reports, metaPolicies, err := v.Policies.validatePage(page, doc)
+	if err != nil {
+		return false, nil, err
+	}
+	if v.Fetcher != nil {
+		resourceReports, err := v.validateResources(page, doc, metaPolicies)
+		if err != nil {
+			return false, nil, err
+		}
+		reports = append(reports, resourceReports...)
+	}
+	return isValid(reports), reports, nil
+}
+
+// validateResources fetches and checks the external scripts and stylesheets
+// of doc, and the stylesheets imported by its inline styles.
+func (v Validator) validateResources(page url.URL, doc *document, metaPolicies []metaPolicy) ([]Report, error) {
+	visited := map[string]bool{}
+	var reports []Report
+	var err2 error
+	doc.Find("script[src], link[rel=stylesheet][href], style").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		node := s.Nodes[0]
+		policies := append(PolicySet{}, v.Policies...)
+		for _, meta := range metaPolicies {
+			if meta.include(node) {
+				policies = append(policies, meta.policy)
+			}
+		}
+		policies = policies.withSandbox()
+
+		var resourceReports []Report
+		var err error
+		switch strings.ToLower(node.Data) {
+		case "style":
+			ctx := SourceContext{
+				Page:         page,
+				Nonce:        s.AttrOr("nonce", ""),
+				Style:        true,
+				Body:         []byte(s.Text()),
+				UnsafeInline: true,
+			}
+			resourceReports, err = v.validateInlineStyle(policies, ctx, visited)
+
+		case "script":
+			ctx := SourceContext{
+				Page:      page,
+				Nonce:     s.AttrOr("nonce", ""),
+				Script:    true,
+				Integrity: ParseIntegrity(s.AttrOr("integrity", "")),
+			}
+			// Scripts are only fetched to check their integrity.
+			if len(ctx.Integrity) == 0 {
+				return true
+			}
+			if ctx.URL, err = resolveAttr(page, s, "src"); err == nil {
+				resourceReports, err = v.validateResource(policies, "script-src-elem", ctx, visited)
+			}
+
+		default:
+			ctx := SourceContext{
+				Page:      page,
+				Nonce:     s.AttrOr("nonce", ""),
+				Style:     true,
+				Integrity: ParseIntegrity(s.AttrOr("integrity", "")),
+			}
+			if ctx.URL, err = resolveAttr(page, s, "href"); err == nil {
+				resourceReports, err = v.validateResource(policies, "style-src-elem", ctx, visited)
+			}
+		}
+		if err != nil {
+			err2 = err
+			return false
+		}
+		// Violations in stylesheets keep their position in the stylesheet.
+		for i, r := range resourceReports {
+			if len(r.SourceFile) == 0 {
+				resourceReports[i].Position = doc.position(node, "")
+			}
+		}
+		reports = append(reports, resourceReports...)
+		return true
+	})
+	if err2 != nil {
+		return nil, err2
+	}
+	return reports, nil
+}
+
+// resolveAttr resolves the URL in the attribute of s against page.
+func resolveAttr(page url.URL, s *goquery.Selection, attrName string) (url.URL, error) {
+	parsed, err := url.Parse(s.AttrOr(attrName, ""))
+	if err != nil {
+		return url.URL{}, err
+	}
+	return *page.ResolveReference(parsed), nil
+}
+
+// validateInlineStyle fetches and checks the stylesheets imported by an inline
+// style if the policies allow it to be applied.
+func (v Validator) validateInlineStyle(ps PolicySet, ctx SourceContext, visited map[string]bool) ([]Report, error) {
+	allowed, err := ps.allows("style-src-elem", ctx)
+	if err != nil || !allowed {
+		return nil, err
+	}
+	stylesheet, err := parser.Parse(string(ctx.Body))
+	if err != nil {
+		return nil, err
+	}
+	return v.validateImports(ps, ctx.Page, ctx.Page, stylesheet, visited)
+}
+
+// validateResource fetches the resource of ctx if the policies allow it to be
+// loaded. It returns a report if it doesn't match its integrity metadata and,
+// for stylesheets, the violations of the stylesheet and its imports.
+func (v Validator) validateResource(ps PolicySet, directiveName string, ctx SourceContext, visited map[string]bool) ([]Report, error) {
+	for _, p := range ps {
+		if p.UpgradeInsecureRequests && ctx.Page.Scheme == "https" && ctx.URL.Scheme == "http" {
+			ctx.URL.Scheme = "https"
+		}
+	}
+	allowed, err := ps.allows(directiveName, ctx)
+	if err != nil || !allowed {
+		return nil, err
+	}
+	key := ctx.URL.String()
+	if visited[key] {
+		return nil, nil
+	}
+	visited[key] = true
+
+	res, err := v.Fetcher.Fetch(ctx.URL)
+	if errors.Cause(err) == ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var reports []Report
+	if len(res.Redirects) > 0 {
+		redirectReports, allowed, err := checkRedirects(ps, directiveName, ctx, res)
+		if err != nil || !allowed {
+			return redirectReports, err
+		}
+		reports = append(reports, redirectReports...)
+	}
+
+	// Browsers refuse resources that don't match their integrity metadata
+	// whatever the policies are, so this is always a violation.
+	if len(ctx.Integrity) > 0 && !matchesBody(ctx.Integrity, res.Body) {
+		r := ctx.Report("integrity", nil)
+		r.EffectiveDirective = directiveName
+		return append(reports, r), nil
+	}
+	if !ctx.Style {
+		return reports, nil
+	}
+
+	source := string(res.Body)
+	stylesheet, err := parser.Parse(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", key)
+	}
+	stylesheetReports, err := ps.validateStylesheet(ctx.Page, res.URL, stylesheet, source)
+	if err != nil {
+		return nil, err
+	}
+	for i := range stylesheetReports {
+		stylesheetReports[i].SourceFile = res.URL.String()
+	}
+	reports = append(reports, stylesheetReports...)
+
+	importReports, err := v.validateImports(ps, ctx.Page, res.URL, stylesheet, visited)
+	if err != nil {
+		return nil, err
+	}
+	return append(reports, importReports...), nil
+}
+
+// validateImports fetches and checks the stylesheets imported by stylesheet,
+// resolving their URLs against base.
+func (v Validator) validateImports(ps PolicySet, page, base url.URL, stylesheet *css.Stylesheet, visited map[string]bool) ([]Report, error) {
+	imports, err := stylesheetImports(stylesheet.Rules)
+	if err != nil {
+		return nil, err
+	}
+	var reports []Report
+	for _, imp := range imports {
+		parsed, err := url.Parse(imp)
+		if err != nil {
+			return nil, err
+		}
+		ctx := SourceContext{
+			URL:   *base.ResolveReference(parsed),
+			Page:  page,
+			Style: true,
+		}
+		importReports, err := v.validateResource(ps, "style-src-elem", ctx, visited)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, importReports...)
+	}
+	return reports, nil
+}
+
+// stylesheetImports returns the URLs of the @import rules in rules and any
+// rules nested inside of them.
+func stylesheetImports(rules []*css.Rule) ([]string, error) {
+	var imports []string
+	for _, rule := range rules {
+		if rule.Name == "@import" {
+			parts := strings.Fields(rule.Prelude)
+			if len(parts) == 0 {
+				continue
+			}
+			imp, err := parseCSSURL(parts[0])
+			if err != nil {
+				return nil, err
+			}
+			imports = append(imports, imp)
+		} else if rule.EmbedsRules() {
+			nested, err := stylesheetImports(rule.Rules)
+			if err != nil {
+				return nil, err
+			}
+			imports = append(imports, nested...)
+		}
+	}
+	return imports, nil
+}
+
+// checkRedirects checks every request of the redirect chain of a fetched
+// resource. The original request was already checked before fetching, so only
+// the requests after it are checked. The resource is only allowed if no
+// enforced policy blocks it.
+func checkRedirects(ps PolicySet, directiveName string, ctx SourceContext, res Resource) ([]Report, bool, error) {
+	ctx.URL = res.URL
+	ctx.Redirects = res.Redirects
+	hops := ctx.hops()
+	var reports []Report
+	for _, p := range ps {
+		for _, hop := range hops[1:] {
+			r, blocked, err := p.checkHop(directiveName, hop)
+			if err != nil {
+				return nil, false, err
+			}
+			if blocked {
+				reports = append(reports, r)
+				break
+			}
+		}
+	}
+	return reports, isValid(reports), nil
+}
@@ -0,0 +1,324 @@
+package csp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sha256Integrity(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestValidatorFetch(t *testing.T) {
+	t.Parallel()
+
+	page, err := url.Parse("https://example.com/app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher := MapFetcher{
+		"https://example.com/css/app.css":   `@import "theme.css"; a { background: url(/ok.png); }`,
+		"https://example.com/css/theme.css": "\n@font-face { src: url(https://evil.com/font.woff); }",
+		"https://example.com/css/loop.css":  `@import "loop.css"; a { background: url(https://evil.com/a.png); }`,
+		"https://example.com/css/media.css": `@media print { @import "theme.css"; }`,
+		"https://cdn.com/lib.js":            "lib()",
+		"https://evil.com/evil.css":         `a { background: url(https://evil.com/a.png); }`,
+	}
+
+	cases := []struct {
+		policy string
+		html   string
+		valid  bool
+		want   []string
+	}{
+		{
+			"default-src 'self'",
+			`<link rel="stylesheet" href="/css/app.css">`,
+			false,
+			[]string{"https://evil.com/font.woff font-src https://example.com/css/theme.css 2:23"},
+		},
+		{
+			"default-src 'self' https://cdn.com",
+			`<script src="https://cdn.com/lib.js" integrity="` + sha256Integrity("lib()") + `"></script>`,
+			true,
+			nil,
+		},
+		{
+			"default-src 'self' https://cdn.com",
+			`<script src="https://cdn.com/lib.js" integrity="` + sha256Integrity("evil()") + `"></script>`,
+			false,
+			[]string{"https://cdn.com/lib.js integrity  1:1"},
+		},
+		{
+			// Imports of inline styles are followed if the style is allowed.
+			"style-src 'self' 'unsafe-inline'; img-src 'self'",
+			`<style>@import url(/css/loop.css);</style>`,
+			false,
+			[]string{"https://evil.com/a.png img-src https://example.com/css/loop.css 1:41"},
+		},
+		{
+			"style-src 'self'; img-src 'self'",
+			`<style>@import url(/css/loop.css);</style>`,
+			false,
+			[]string{" style-src-elem  1:1"},
+		},
+		{
+			// Imports nested in other rules are followed too.
+			"default-src 'self'",
+			`<link rel="stylesheet" href="/css/media.css">`,
+			false,
+			[]string{"https://evil.com/font.woff font-src https://example.com/css/theme.css 2:23"},
+		},
+		{
+			"default-src 'self'",
+			`<link rel="stylesheet" href="/css/loop.css"><link rel="stylesheet" href="/css/loop.css">`,
+			false,
+			[]string{"https://evil.com/a.png img-src https://example.com/css/loop.css 1:41"},
+		},
+		{
+			// Blocked stylesheets aren't loaded by browsers so they aren't
+			// checked.
+			"default-src 'self'",
+			`<link rel="stylesheet" href="https://evil.com/evil.css">`,
+			false,
+			[]string{"https://evil.com/evil.css style-src-elem  1:1"},
+		},
+		{
+			// Missing resources are skipped.
+			"default-src 'self'",
+			`<link rel="stylesheet" href="/css/missing.css">`,
+			true,
+			nil,
+		},
+	}
+
+	for i, c := range cases {
+		p, err := ParsePolicy(c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := Validator{Policies: PolicySet{p}, Fetcher: fetcher}
+		valid, reports, err := v.ValidatePage(*page, strings.NewReader(c.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != c.valid {
+			t.Errorf("%d. ValidatePage(%q) = %v; not %v", i, c.html, valid, c.valid)
+		}
+		var got []string
+		for _, r := range reports {
+			got = append(got, fmt.Sprintf("%s %s %s %d:%d", r.Blocked, r.DirectiveName, r.SourceFile, r.Position.Line, r.Position.Column))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%d. ValidatePage(%q) reports = %q; not %q", i, c.html, got, c.want)
+		}
+	}
+}
+
+func TestValidatorIntegrityMismatch(t *testing.T) {
+	t.Parallel()
+
+	page, err := url.Parse("https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePolicy("script-src https://cdn.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Disposition = DispositionReport
+	html := `<script src="https://cdn.com/lib.js" integrity="` + sha256Integrity("lib()") + `"></script>`
+
+	// Browsers refuse resources that don't match their integrity metadata
+	// even if no enforced policy applies.
+	for i, ps := range []PolicySet{{p}, nil} {
+		v := Validator{
+			Policies: ps,
+			Fetcher:  MapFetcher{"https://cdn.com/lib.js": "evil()"},
+		}
+		valid, reports, err := v.ValidatePage(*page, strings.NewReader(html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid || len(reports) != 1 {
+			t.Fatalf("%d. ValidatePage() = %v, %+v; expected 1 integrity report", i, valid, reports)
+		}
+		if r := reports[0]; r.DirectiveName != "integrity" || r.Disposition != DispositionEnforce {
+			t.Errorf("%d. report = %+v; expected an enforced integrity report", i, r)
+		}
+	}
+}
+
+func TestDirFetcher(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "csp-fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "css"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "css", "app.css"), []byte("a {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := url.Parse("https://example.com/static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := DirFetcher{Dir: dir, Base: *base}
+
+	cases := []struct {
+		url  string
+		body string
+		err  string
+	}{
+		{"https://example.com/static/css/app.css", "a {}", ""},
+		{"https://example.com/static/css/missing.css", "", "resource not found"},
+		{"https://example.com/static/../../etc/passwd", "", "resource not found"},
+		{"https://example.com/css/app.css", "", "not under"},
+		{"https://other.com/static/css/app.css", "", "not under"},
+	}
+	for i, c := range cases {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := f.Fetch(*u)
+		checkErr(t, err, c.err)
+		if string(res.Body) != c.body {
+			t.Errorf("%d. Fetch(%q) = %q; not %q", i, c.url, res.Body, c.body)
+		}
+	}
+}
+
+func TestHTTPFetcher(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app.css":
+			fmt.Fprint(w, "a {}")
+		case "/error.css":
+			http.Error(w, "error", http.StatusInternalServerError)
+		case "/large.css":
+			fmt.Fprint(w, strings.Repeat(" ", maxFetchSize+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	cases := []struct {
+		path string
+		body string
+		err  string
+	}{
+		{"/app.css", "a {}", ""},
+		{"/missing.css", "", "resource not found"},
+		{"/error.css", "", "500"},
+		{"/large.css", "", "body larger than"},
+	}
+	for i, c := range cases {
+		u, err := url.Parse(ts.URL + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := HTTPFetcher{}.Fetch(*u)
+		checkErr(t, err, c.err)
+		if string(res.Body) != c.body {
+			t.Errorf("%d. Fetch(%q) = %q; not %q", i, c.path, res.Body, c.body)
+		}
+	}
+}
+
+func TestValidatorFetchRedirects(t *testing.T) {
+	t.Parallel()
+
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "a { background: url(/bg.png); }")
+	}))
+	defer cdn.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app.css":
+			http.Redirect(w, r, cdn.URL+"/app.css", http.StatusFound)
+		default:
+			fmt.Fprint(w, "a {}")
+		}
+	}))
+	defer origin.Close()
+
+	page, err := url.Parse(origin.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := `<link rel="stylesheet" href="/app.css">`
+
+	cases := []struct {
+		policy string
+		valid  bool
+		want   []string
+	}{
+		{"default-src 'self' " + cdn.URL, true, nil},
+		{"default-src 'self'", false, []string{cdn.URL + "/app.css style-src-elem"}},
+		{"style-src 'self'; img-src 'self'", false, []string{cdn.URL + "/app.css style-src-elem"}},
+		{"style-src 'self' " + cdn.URL + "; img-src 'self'", false, []string{cdn.URL + "/bg.png img-src"}},
+	}
+
+	for i, c := range cases {
+		p, err := ParsePolicy(c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := Validator{Policies: PolicySet{p}, Fetcher: HTTPFetcher{}}
+		valid, reports, err := v.ValidatePage(*page, strings.NewReader(html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != c.valid {
+			t.Errorf("%d. ValidatePage(%q) = %v; not %v", i, c.policy, valid, c.valid)
+		}
+		var got []string
+		for _, r := range reports {
+			got = append(got, r.Blocked+" "+r.DirectiveName)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%d. ValidatePage(%q) reports = %q; not %q", i, c.policy, got, c.want)
+		}
+	}
+}
+
+func TestCheckRedirectsBackToOriginal(t *testing.T) {
+	t.Parallel()
+
+	p, err := ParsePolicy("style-src https://cdn.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Disposition = DispositionReport
+	urls := parseURLs(t, "https://example.com/", "https://example.com/a.css", "https://cdn.com/a.css")
+	ctx := SourceContext{Page: urls[0], URL: urls[1], Style: true}
+	// The redirect back to the original URL is reported even though the
+	// original request was already reported.
+	res := Resource{URL: urls[1], Redirects: urls[1:]}
+	reports, _, err := checkRedirects(PolicySet{p}, "style-src-elem", ctx, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Blocked != urls[1].String() || len(reports[0].Context.Redirects) != 2 {
+		t.Errorf("checkRedirects() = %+v; expected a report for the last request", reports)
+	}
+}
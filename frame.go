@@ -0,0 +1,120 @@
+package csp
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CheckFrameAncestors checks whether page may be embedded by the frames in
+// ancestors, which are ordered from the parent of page up to the top-level
+// page. Only the origins of the ancestors are used, so the paths of host
+// sources are ignored. frame-ancestors is matched against 'self', schemes and
+// hosts; nonces, hashes and 'unsafe-inline' never allow embedding. Policies
+// without frame-ancestors allow any ancestor.
+func (p Policy) CheckFrameAncestors(page url.URL, ancestors []url.URL) (bool, []Report) {
+	return PolicySet{p}.CheckFrameAncestors(page, ancestors)
+}
+
+// CheckFrameAncestors checks every policy in the set against the ancestors of
+// page. Like ValidatePage, only enforced policies affect whether embedding is
+// allowed.
+func (ps PolicySet) CheckFrameAncestors(page url.URL, ancestors []url.URL) (bool, []Report) {
+	var reports []Report
+	for _, p := range ps {
+		reports = append(reports, checkFrameAncestors(p, page, ancestors)...)
+	}
+	return isValid(reports), reports
+}
+
+// checkFrameAncestors returns a report for the first ancestor that the policy
+// doesn't allow, as browsers stop checking at the first violation.
+func checkFrameAncestors(p Policy, page url.URL, ancestors []url.URL) []Report {
+	d, ok := p.Directives["frame-ancestors"].(SourceDirective)
+	if !ok {
+		return nil
+	}
+	for _, ancestor := range ancestors {
+		ancestor = origin(ancestor)
+		// The ancestors are checked before the page is sandboxed, so 'self'
+		// matches even without allow-same-origin.
+		if !d.None && (d.Self && matchSelf(page, ancestor) || d.matchesSource(page, ancestor, true)) {
+			continue
+		}
+		ctx := SourceContext{
+			URL:  ancestor,
+			Page: page,
+		}
+		return []Report{p.report(ctx, "frame-ancestors", d)}
+	}
+	return nil
+}
+
+// origin returns the scheme and host of u.
+func origin(u url.URL) url.URL {
+	return url.URL{Scheme: u.Scheme, Host: u.Host}
+}
+
+// CheckFraming checks whether a page served with the response headers h may be
+// embedded by ancestors. X-Frame-Options is used if no enforced policy in the
+// Content-Security-Policy headers has frame-ancestors, as browsers do.
+// Violations of X-Frame-Options are reported with the DirectiveName
+// X-Frame-Options.
+func CheckFraming(h http.Header, page url.URL, ancestors []url.URL) (bool, []Report, error) {
+	ps, err := ParseHeader(h)
+	if err != nil {
+		return false, nil, err
+	}
+	valid, reports := ps.CheckFrameAncestors(page, ancestors)
+	for _, p := range ps {
+		if _, ok := p.Directives["frame-ancestors"]; ok && p.Disposition == DispositionEnforce {
+			return valid, reports, nil
+		}
+	}
+	if r, blocked := checkXFrameOptions(h, page, ancestors); blocked {
+		reports = append(reports, r)
+	}
+	return isValid(reports), reports, nil
+}
+
+// checkXFrameOptions checks the X-Frame-Options headers in h following the
+// HTML standard. Unknown values such as the obsolete ALLOW-FROM are ignored.
+func checkXFrameOptions(h http.Header, page url.URL, ancestors []url.URL) (Report, bool) {
+	values := map[string]bool{}
+	for _, header := range h["X-Frame-Options"] {
+		for _, value := range strings.Split(header, ",") {
+			values[strings.ToLower(strings.TrimSpace(value))] = true
+		}
+	}
+	if len(ancestors) == 0 {
+		return Report{}, false
+	}
+	block := func(ancestor url.URL) (Report, bool) {
+		ctx := SourceContext{
+			URL:  origin(ancestor),
+			Page: page,
+		}
+		r := ctx.Report("frame-ancestors", nil)
+		r.DirectiveName = "X-Frame-Options"
+		r.ViolatedDirective = "X-Frame-Options"
+		return r, true
+	}
+	if len(values) > 1 {
+		// Conflicting values block if any of them would.
+		if values["deny"] || values["sameorigin"] || values["allowall"] {
+			return block(ancestors[0])
+		}
+		return Report{}, false
+	}
+	if values["deny"] {
+		return block(ancestors[0])
+	}
+	if values["sameorigin"] {
+		for _, ancestor := range ancestors {
+			if ancestor.Scheme != page.Scheme || ancestor.Host != page.Host {
+				return block(ancestor)
+			}
+		}
+	}
+	return Report{}, false
+}
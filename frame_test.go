@@ -0,0 +1,121 @@
+package csp
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func parseURLs(t *testing.T, urls ...string) []url.URL {
+	var parsed []url.URL
+	for _, u := range urls {
+		p, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, *p)
+	}
+	return parsed
+}
+
+func TestCheckFrameAncestors(t *testing.T) {
+	t.Parallel()
+
+	page := parseURLs(t, "https://example.com/account")[0]
+
+	cases := []struct {
+		policy    string
+		ancestors []string
+		valid     bool
+	}{
+		{"default-src 'none'", []string{"https://evil.com"}, true},
+		{"frame-ancestors 'none'", nil, true},
+		{"frame-ancestors 'none'", []string{"https://example.com"}, false},
+		{"frame-ancestors 'self'", []string{"https://example.com/foo"}, true},
+		{"frame-ancestors 'self'", []string{"https://example.com", "https://evil.com"}, false},
+		{"frame-ancestors 'self'", []string{"http://example.com"}, false},
+		{"frame-ancestors 'self' https://partner.com", []string{"https://partner.com/embed", "https://example.com"}, true},
+		{"frame-ancestors https:", []string{"https://partner.com"}, true},
+		{"frame-ancestors *.partner.com", []string{"https://www.partner.com"}, true},
+		{"frame-ancestors 'unsafe-inline' 'nonce-foo'", []string{"https://partner.com"}, false},
+		// Ancestors are checked before the page is sandboxed.
+		{"frame-ancestors 'self'; sandbox", []string{"https://example.com"}, true},
+		// Only the origin of ancestors is known, so paths aren't matched.
+		{"frame-ancestors https://partner.com/embed/", []string{"https://partner.com/page"}, true},
+		{"frame-ancestors https://partner.com/embed/", []string{"https://evil.com/embed/"}, false},
+	}
+
+	for i, c := range cases {
+		p, err := ParsePolicy(c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		valid, reports := p.CheckFrameAncestors(page, parseURLs(t, c.ancestors...))
+		if valid != c.valid {
+			t.Errorf("%d. CheckFrameAncestors(%q, %q) = %v; not %v; reports = %+v", i, c.policy, c.ancestors, valid, c.valid, reports)
+		}
+	}
+}
+
+func TestCheckFraming(t *testing.T) {
+	t.Parallel()
+
+	page := parseURLs(t, "https://example.com/account")[0]
+
+	cases := []struct {
+		headers   map[string][]string
+		ancestors []string
+		valid     bool
+		directive string
+	}{
+		{nil, []string{"https://evil.com"}, true, ""},
+		{map[string][]string{"X-Frame-Options": {"DENY"}}, []string{"https://example.com"}, false, "X-Frame-Options"},
+		{map[string][]string{"X-Frame-Options": {"DENY"}}, nil, true, ""},
+		{map[string][]string{"X-Frame-Options": {"sameorigin"}}, []string{"https://example.com"}, true, ""},
+		{map[string][]string{"X-Frame-Options": {"SAMEORIGIN"}}, []string{"https://example.com", "https://evil.com"}, false, "X-Frame-Options"},
+		{map[string][]string{"X-Frame-Options": {"ALLOW-FROM https://evil.com"}}, []string{"https://evil.com"}, true, ""},
+		{map[string][]string{"X-Frame-Options": {"SAMEORIGIN, DENY"}}, []string{"https://example.com"}, false, "X-Frame-Options"},
+		{
+			map[string][]string{
+				"X-Frame-Options":         {"DENY"},
+				"Content-Security-Policy": {"frame-ancestors https://partner.com"},
+			},
+			[]string{"https://partner.com"}, true, "",
+		},
+		{
+			map[string][]string{
+				"X-Frame-Options":         {"SAMEORIGIN"},
+				"Content-Security-Policy": {"frame-ancestors 'self'"},
+			},
+			[]string{"https://evil.com"}, false, "frame-ancestors",
+		},
+		{
+			// Report-only policies don't replace X-Frame-Options.
+			map[string][]string{
+				"X-Frame-Options":                     {"DENY"},
+				"Content-Security-Policy-Report-Only": {"frame-ancestors https://partner.com"},
+			},
+			[]string{"https://partner.com"}, false, "X-Frame-Options",
+		},
+	}
+
+	for i, c := range cases {
+		h := http.Header{}
+		for name, values := range c.headers {
+			for _, v := range values {
+				h.Add(name, v)
+			}
+		}
+		valid, reports, err := CheckFraming(h, page, parseURLs(t, c.ancestors...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != c.valid {
+			t.Errorf("%d. CheckFraming(%v, %q) = %v; not %v; reports = %+v", i, c.headers, c.ancestors, valid, c.valid, reports)
+		}
+		enforced, _ := SplitReports(reports)
+		if len(c.directive) > 0 && (len(enforced) != 1 || enforced[0].DirectiveName != c.directive) {
+			t.Errorf("%d. CheckFraming(%v, %q) reports = %+v; expected %s", i, c.headers, c.ancestors, reports, c.directive)
+		}
+	}
+}
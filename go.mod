@@ -5,7 +5,7 @@ go 1.11
 require (
 	github.com/PuerkitoBio/goquery v1.5.0
 	github.com/aymerick/douceur v0.2.0
-	github.com/gobwas/glob v0.2.3
 	github.com/gorilla/css v1.0.0 // indirect
 	github.com/pkg/errors v0.8.1
+	golang.org/x/net v0.0.0-20181114220301-adae6a3d119a
 )
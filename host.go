@@ -0,0 +1,209 @@
+package csp
+
+import (
+	"net/url"
+	"strings"
+)
+
+// defaultPorts are the ports used by URLs with these schemes that don't
+// specify one.
+var defaultPorts = map[string]string{
+	"ftp":   "21",
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+}
+
+// HostSource is a SourceDirective rule that matches URLs by host, such as
+// https://*.example.com, and optionally by port and path, such as
+// https://cdn.example.com:8443/js/.
+type HostSource struct {
+	// Source is the host source expression from the policy.
+	Source string
+
+	// scheme is empty if the expression doesn't have a scheme, in which case
+	// the scheme of the page is used.
+	scheme string
+	// host is "*", a host or a host with a "*." prefix to match subdomains.
+	host string
+	// port is "*", a port or empty to only match the default port.
+	port string
+	// path is the path part of the expression, which is empty if it matches
+	// every path.
+	path string
+}
+
+// ParseHostSource parses a host source expression of the form
+// [scheme://]host[:port][/path]. Like in browsers, malformed parts aren't
+// errors but never match.
+func ParseHostSource(source string) (HostSource, error) {
+	h := HostSource{
+		Source: source,
+	}
+	rest := source
+	if i := strings.Index(rest, "://"); i >= 0 {
+		h.scheme = strings.ToLower(rest[:i])
+		rest = rest[i+len("://"):]
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		h.path = rest[i:]
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		h.port = rest[i+1:]
+		rest = rest[:i]
+	}
+	h.host = strings.ToLower(rest)
+	return h, nil
+}
+
+// Match returns whether the URL is matched by the host source. Sources without
+// a scheme are matched as if the page was loaded with the scheme of the URL.
+func (h HostSource) Match(u url.URL) bool {
+	return h.match(u, u, false)
+}
+
+// match implements the CSP3 matching of a URL loaded by page against a host
+// source. As required by CSP3, the path isn't checked if the URL was reached
+// by a redirect so the redirect target isn't revealed by a violation.
+func (h HostSource) match(page, u url.URL, redirected bool) bool {
+	scheme := strings.ToLower(u.Scheme)
+	// The bare wildcard matches network schemes and the scheme of the page.
+	if h.Source == "*" {
+		switch scheme {
+		case "http", "https", "ws", "wss":
+			return true
+		}
+		return scheme == strings.ToLower(page.Scheme)
+	}
+	if len(u.Hostname()) == 0 {
+		return false
+	}
+	if len(h.scheme) > 0 {
+		if !matchScheme(h.scheme, scheme) {
+			return false
+		}
+	} else if !matchScheme(strings.ToLower(page.Scheme), scheme) {
+		return false
+	}
+	return matchHost(h.host, u.Hostname()) &&
+		matchPort(h.port, u) &&
+		(redirected || matchPath(h.path, u))
+}
+
+// String returns the host source expression.
+func (h HostSource) String() string {
+	return h.Source
+}
+
+// matchScheme implements CSP3 scheme-part matching. Besides an exact match,
+// insecure schemes also match their secure upgrades, and ws: matches http:
+// too.
+func matchScheme(pattern, scheme string) bool {
+	switch pattern {
+	case scheme:
+		return true
+	case "http":
+		return scheme == "https"
+	case "ws":
+		return scheme == "wss" || scheme == "http" || scheme == "https"
+	case "wss":
+		return scheme == "https"
+	}
+	return false
+}
+
+// matchHost implements CSP3 host-part matching. A "*." prefix matches any
+// subdomain, but not the domain itself.
+func matchHost(pattern, host string) bool {
+	host = strings.ToLower(host)
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+// urlPort returns the port of u, or an empty string if it's the default port
+// for its scheme.
+func urlPort(u url.URL) string {
+	port := u.Port()
+	if port == defaultPorts[strings.ToLower(u.Scheme)] {
+		return ""
+	}
+	return port
+}
+
+// matchPort implements CSP3 port-part matching. An empty pattern only matches
+// the default port of the URL's scheme.
+func matchPort(pattern string, u url.URL) bool {
+	if pattern == "*" {
+		return true
+	}
+	port := urlPort(u)
+	if pattern == port {
+		return true
+	}
+	return len(port) == 0 && pattern == defaultPorts[strings.ToLower(u.Scheme)]
+}
+
+// matchSelf implements CSP3 'self' matching. Besides the same origin, URLs on
+// the same host and port are allowed over secure schemes, and over ws: from
+// pages loaded over http:.
+func matchSelf(page, u url.URL) bool {
+	if len(u.Hostname()) == 0 || !strings.EqualFold(page.Hostname(), u.Hostname()) {
+		return false
+	}
+	if urlPort(page) != urlPort(u) {
+		return false
+	}
+	pageScheme, scheme := strings.ToLower(page.Scheme), strings.ToLower(u.Scheme)
+	if pageScheme == scheme {
+		return true
+	}
+	return scheme == "https" || scheme == "wss" ||
+		pageScheme == "http" && (scheme == "http" || scheme == "ws")
+}
+
+// matchPath implements the CSP3 path-part matching algorithm. A path ending in
+// "/" matches every path under it, otherwise the path has to match exactly.
+// Segments are compared after percent-decoding.
+func matchPath(path string, u url.URL) bool {
+	if len(path) == 0 || path == "/" {
+		return true
+	}
+	urlPath := u.EscapedPath()
+	if len(urlPath) == 0 {
+		urlPath = "/"
+	}
+
+	exact := !strings.HasSuffix(path, "/")
+	pathParts := strings.Split(path, "/")
+	urlParts := strings.Split(urlPath, "/")
+	if len(pathParts) > len(urlParts) {
+		return false
+	}
+	if exact && len(pathParts) != len(urlParts) {
+		return false
+	}
+	if !exact {
+		pathParts = pathParts[:len(pathParts)-1]
+	}
+	for i, part := range pathParts {
+		a, err := url.PathUnescape(part)
+		if err != nil {
+			return false
+		}
+		b, err := url.PathUnescape(urlParts[i])
+		if err != nil {
+			return false
+		}
+		if a != b {
+			return false
+		}
+	}
+	return true
+}
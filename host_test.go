@@ -0,0 +1,174 @@
+package csp
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestHostSourcePath(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		source, url string
+		want        bool
+	}{
+		{"https://cdn.com", "https://cdn.com/any/path.js", true},
+		{"https://cdn.com/", "https://cdn.com/any/path.js", true},
+		{"https://cdn.com/js/", "https://cdn.com/js/app.js", true},
+		{"https://cdn.com/js/", "https://cdn.com/js/lib/app.js", true},
+		{"https://cdn.com/js/", "https://cdn.com/css/app.css", false},
+		{"https://cdn.com/js/", "https://cdn.com/js", false},
+		{"https://cdn.com/js/", "https://cdn.com/jsx/app.js", false},
+		{"https://cdn.com/js/app.js", "https://cdn.com/js/app.js", true},
+		{"https://cdn.com/js/app.js", "https://cdn.com/js/app.js?v=1", true},
+		{"https://cdn.com/js/app.js", "https://cdn.com/js/app.js/", false},
+		{"https://cdn.com/js/app.js", "https://cdn.com/js/other.js", false},
+		{"https://cdn.com/js/app.js", "https://cdn.com/js/", false},
+		{"https://cdn.com/my%20js/", "https://cdn.com/my js/app.js", true},
+		{"https://cdn.com/my js/", "https://cdn.com/my%20js/app.js", true},
+		{"https://cdn.com/a%2Fb", "https://cdn.com/a/b", false},
+		{"cdn.com/js/", "https://cdn.com/js/app.js", true},
+		{"*.cdn.com/js/", "https://www.cdn.com/js/app.js", true},
+		{"*.cdn.com/js/", "https://www.cdn.com/css/app.css", false},
+		{"https://cdn.com:443/js/", "https://cdn.com:443/js/app.js", true},
+	}
+
+	for i, c := range cases {
+		h, err := ParseHostSource(c.source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := h.Match(*u); got != c.want {
+			t.Errorf("%d. ParseHostSource(%q).Match(%q) = %v; not %v", i, c.source, c.url, got, c.want)
+		}
+	}
+}
+
+func TestHostSourcePathRedirect(t *testing.T) {
+	t.Parallel()
+
+	p, err := ParsePolicy("script-src https://cdn.com/js/app.js https://mirror.com/js/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := parseURLs(t, "https://example.com", "https://cdn.com/js/app.js", "https://mirror.com/other/app.js", "https://evil.com/js/app.js")
+
+	cases := []struct {
+		url       url.URL
+		redirects []url.URL
+		want      bool
+	}{
+		{urls[1], nil, true},
+		{urls[2], nil, false},
+		{urls[2], urls[1:2], true},
+		{urls[3], urls[1:2], false},
+	}
+	for i, c := range cases {
+		ctx := SourceContext{
+			Page:      urls[0],
+			URL:       c.url,
+			Redirects: c.redirects,
+		}
+		got, err := p.Directive("script-src-elem").Check(p, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%d. Check(%s, %v) = %v; not %v", i, c.url.String(), c.redirects, got, c.want)
+		}
+	}
+}
+
+// TestSourceMatchingConformance covers the CSP3 scheme-part, host-part and
+// port-part matching algorithms and 'self'.
+func TestSourceMatchingConformance(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		source, page, url string
+		want              bool
+	}{
+		// Scheme sources.
+		{"https:", "https://a.com", "https://b.com", true},
+		{"https:", "https://a.com", "http://b.com", false},
+		{"http:", "https://a.com", "https://b.com", true},
+		{"http:", "https://a.com", "wss://b.com", false},
+		{"ws:", "https://a.com", "wss://b.com", true},
+		{"ws:", "https://a.com", "http://b.com", true},
+		{"wss:", "https://a.com", "https://b.com", true},
+		{"wss:", "https://a.com", "ws://b.com", false},
+		{"data:", "https://a.com", "data:image/png;base64,AAAA", true},
+		{"DATA:", "https://a.com", "data:image/png;base64,AAAA", true},
+
+		// Host sources with a scheme.
+		{"https://b.com", "https://a.com", "https://b.com/x", true},
+		{"http://b.com", "https://a.com", "https://b.com/x", true},
+		{"https://b.com", "https://a.com", "http://b.com/x", false},
+		{"ws://b.com", "https://a.com", "wss://b.com/x", true},
+		{"wss://b.com", "https://a.com", "ws://b.com/x", false},
+		{"HTTPS://B.com", "https://a.com", "https://b.COM/x", true},
+
+		// Host sources without a scheme use the scheme of the page.
+		{"b.com", "https://a.com", "https://b.com", true},
+		{"b.com", "https://a.com", "http://b.com", false},
+		{"b.com", "http://a.com", "https://b.com", true},
+		{"b.com", "http://a.com", "http://b.com", true},
+		{"b.com", "https://a.com", "data:b.com", false},
+
+		// Host parts.
+		{"*.b.com", "https://a.com", "https://c.b.com", true},
+		{"*.b.com", "https://a.com", "https://d.c.b.com", true},
+		{"*.b.com", "https://a.com", "https://b.com", false},
+		{"*.b.com", "https://a.com", "https://cb.com", false},
+		{"*b.com", "https://a.com", "https://cb.com", false},
+		{"b.com", "https://a.com", "https://c.b.com", false},
+
+		// Port parts.
+		{"b.com", "https://a.com", "https://b.com:443", true},
+		{"b.com", "https://a.com", "https://b.com:8443", false},
+		{"b.com:443", "https://a.com", "https://b.com", true},
+		{"b.com:443", "https://a.com", "https://b.com:443", true},
+		{"b.com:8443", "https://a.com", "https://b.com:8443", true},
+		{"b.com:8443", "https://a.com", "https://b.com", false},
+		{"http://b.com:80", "https://a.com", "http://b.com", true},
+		{"http://b.com:80", "https://a.com", "https://b.com", false},
+		{"b.com:*", "https://a.com", "https://b.com:1234", true},
+		{"b.com:*", "https://a.com", "https://b.com", true},
+		{"https://*:*", "https://a.com", "https://c.com:99", true},
+
+		// The bare wildcard.
+		{"*", "https://a.com", "https://b.com", true},
+		{"*", "https://a.com", "http://b.com", true},
+		{"*", "https://a.com", "wss://b.com", true},
+		{"*", "https://a.com", "data:text/plain,a", false},
+		{"*", "https://a.com", "blob:https://a.com/1", false},
+		{"*", "file:///index.html", "file:///app.js", true},
+
+		// 'self'.
+		{"'self'", "https://a.com", "https://a.com/x", true},
+		{"'self'", "https://a.com", "https://A.com:443/x", true},
+		{"'self'", "https://a.com", "http://a.com/x", false},
+		{"'self'", "https://a.com", "wss://a.com/x", true},
+		{"'self'", "https://a.com", "ws://a.com/x", false},
+		{"'self'", "http://a.com", "https://a.com/x", true},
+		{"'self'", "http://a.com", "ws://a.com/x", true},
+		{"'self'", "https://a.com", "https://a.com:8443/x", false},
+		{"'self'", "https://a.com:8443", "wss://a.com:8443/x", true},
+		{"'self'", "https://a.com", "https://b.a.com/x", false},
+	}
+
+	for i, c := range cases {
+		s, err := ParseSourceDirective([]string{c.source})
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls := parseURLs(t, c.page, c.url)
+		if got := s.matchesURL(Policy{}, urls[0], urls[1], false); got != c.want {
+			t.Errorf("%d. %q matches %q from %q = %v; not %v", i, c.source, c.url, c.page, got, c.want)
+		}
+	}
+}
@@ -6,16 +6,18 @@ import (
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/aymerick/douceur/parser"
+	"golang.org/x/net/html"
 )
 
 var (
 	htmlDirectiveElements = map[string]string{
-		"script-src": "script",
-		"img-src":    "img",
-		"media-src":  "audio, video, track",
-		"frame-src":  "iframe",
-		"object-src": "object, embed, applet",
-		"style-src":  "style",
+		"script-src-elem": "script",
+		"img-src":         "img",
+		"media-src":       "audio, video, track",
+		"frame-src":       "iframe",
+		"object-src":      "object, embed, applet",
+		"style-src-elem":  "style",
 	}
 
 	htmlPassiveElements = map[string]bool{
@@ -24,26 +26,43 @@ var (
 		"video":  true,
 		"object": true,
 	}
+
+	// htmlNavigationElements are the elements whose href navigates when
+	// activated.
+	htmlNavigationElements = map[string]bool{
+		"a":    true,
+		"area": true,
+	}
 )
 
-// ValidatePage checks that an HTML page passes the specified CSP policy.
+// ValidatePage checks that an HTML page passes the specified CSP policy. If the
+// policy is report-only the page is always valid, but violations are still
+// returned.
 func ValidatePage(p Policy, page url.URL, html io.Reader) (bool, []Report, error) {
-	doc, err := goquery.NewDocumentFromReader(html)
-	if err != nil {
-		return false, nil, err
-	}
+	return PolicySet{p}.ValidatePage(page, html)
+}
+
+// validateDocument returns all the violations of a single policy in doc. If
+// include is set, only the nodes it returns true for are checked.
+func validateDocument(p Policy, page url.URL, doc *document, include func(*html.Node) bool) ([]Report, error) {
 	var reports []Report
 
 	for directiveName, elems := range htmlDirectiveElements {
-		directive := p.Directive(directiveName)
 		var err2 error
 		doc.Find(elems).Each(func(i int, s *goquery.Selection) {
-			ctx := SourceContext{
-				Page:  page,
-				Nonce: s.AttrOr("nonce", ""),
+			if include != nil && !include(s.Nodes[0]) {
+				return
 			}
 
 			elementName := strings.ToLower(s.Nodes[0].Data)
+			ctx := SourceContext{
+				Page:      page,
+				Nonce:     s.AttrOr("nonce", ""),
+				Script:    elementName == "script",
+				Style:     elementName == "style",
+				Integrity: ParseIntegrity(s.AttrOr("integrity", "")),
+			}
+
 			passiveContent := htmlPassiveElements[elementName]
 
 			src := s.AttrOr("src", "")
@@ -66,40 +85,55 @@ func ValidatePage(p Policy, page url.URL, html io.Reader) (bool, []Report, error
 				ctx.URL.Scheme = "https"
 			}
 
-			v, err := directive.Check(p, ctx)
+			checkReports, err := p.check(directiveName, ctx)
 			if err != nil {
 				err2 = err
 				return
 			}
-			if !v {
-				reports = append(reports, ctx.Report(directiveName, directive))
+			sriReports, err := checkIntegrity(p, ctx)
+			if err != nil {
+				err2 = err
+				return
 			}
+			checkReports = append(checkReports, sriReports...)
+			setPosition(checkReports, doc.position(s.Nodes[0], ""))
+			reports = append(reports, checkReports...)
 
 			if goquery.NodeName(s) == "style" {
-				_, reportsCSS, err := ValidateStylesheet(p, page, s.Text())
+				text := s.Text()
+				stylesheet, err := parser.Parse(text)
+				if err != nil {
+					err2 = err
+					return
+				}
+				reportsCSS, err := validateStylesheet(p, page, page, stylesheet, text)
 				if err != nil {
 					err2 = err
 					return
 				}
+				doc.locateContent(reportsCSS, s.Nodes[0])
 				reports = append(reports, reportsCSS...)
 			}
 		})
 		if err2 != nil {
-			return false, nil, err2
+			return nil, err2
 		}
 	}
 
 	hrefTypes := map[string]string{
-		"base-uri":     "base",
-		"style-src":    "link[rel=stylesheet]",
-		"prefetch-src": "link[rel=prefetch], link[rel=prerender]",
-		"manifest-src": "link[rel=manifest]",
-		"img-src":      "link[rel=icon], link[rel=apple-touch-icon]",
+		"base-uri":       "base",
+		"style-src-elem": "link[rel=stylesheet]",
+		"prefetch-src":   "link[rel=prefetch], link[rel=prerender]",
+		"manifest-src":   "link[rel=manifest]",
+		"img-src":        "link[rel=icon], link[rel=apple-touch-icon]",
 	}
 	for directiveName, elems := range hrefTypes {
-		directive := p.Directive(directiveName)
 		var err2 error
 		doc.Find(elems).Each(func(i int, s *goquery.Selection) {
+			if include != nil && !include(s.Nodes[0]) {
+				return
+			}
+
 			ctx := SourceContext{
 				Page:  page,
 				Nonce: s.AttrOr("nonce", ""),
@@ -112,21 +146,179 @@ func ValidatePage(p Policy, page url.URL, html io.Reader) (bool, []Report, error
 					return
 				}
 				ctx.URL = *page.ResolveReference(parsed)
+				if directiveName == "style-src-elem" {
+					ctx.Style = true
+					ctx.Integrity = ParseIntegrity(s.AttrOr("integrity", ""))
+				}
 			}
 
-			v, err := directive.Check(p, ctx)
+			checkReports, err := p.check(directiveName, ctx)
 			if err != nil {
 				err2 = err
 				return
 			}
-			if !v {
-				reports = append(reports, ctx.Report(directiveName, directive))
+			sriReports, err := checkIntegrity(p, ctx)
+			if err != nil {
+				err2 = err
+				return
 			}
+			checkReports = append(checkReports, sriReports...)
+			setPosition(checkReports, doc.position(s.Nodes[0], ""))
+			reports = append(reports, checkReports...)
 		})
 		if err2 != nil {
-			return false, nil, err2
+			return nil, err2
 		}
 	}
 
-	return len(reports) == 0, reports, nil
+	formReports, err := validateForms(p, page, doc, include)
+	if err != nil {
+		return nil, err
+	}
+	reports = append(reports, formReports...)
+
+	attrReports, err := validateInlineAttributes(p, page, doc, include)
+	if err != nil {
+		return nil, err
+	}
+	reports = append(reports, attrReports...)
+
+	sandboxReports, err := validateSandbox(p, page, doc, include)
+	if err != nil {
+		return nil, err
+	}
+	reports = append(reports, sandboxReports...)
+
+	return reports, nil
+}
+
+// validateForms checks the URLs forms submit to against form-action. That's
+// the action of the form, or the page if it's empty, and the formaction of
+// submit buttons.
+func validateForms(p Policy, page url.URL, doc *document, include func(*html.Node) bool) ([]Report, error) {
+	var reports []Report
+	var err2 error
+	doc.Find("form, button[formaction], input[formaction]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		node := s.Nodes[0]
+		if include != nil && !include(node) {
+			return true
+		}
+
+		attrName := "action"
+		if elementName := strings.ToLower(node.Data); elementName != "form" {
+			attrName = "formaction"
+			// Buttons submit by default, but inputs are text fields.
+			defaultType := "submit"
+			if elementName == "input" {
+				defaultType = "text"
+			}
+			submitType := strings.ToLower(s.AttrOr("type", defaultType))
+			if elementName == "button" && submitType != "submit" {
+				return true
+			}
+			if elementName == "input" && submitType != "submit" && submitType != "image" {
+				return true
+			}
+		}
+		parsed, err := url.Parse(strings.TrimSpace(s.AttrOr(attrName, "")))
+		if err != nil {
+			err2 = err
+			return false
+		}
+		ctx := SourceContext{
+			Page: page,
+			URL:  *page.ResolveReference(parsed),
+			Form: true,
+		}
+		if ctx.Page.Scheme == "https" && ctx.URL.Scheme == "http" && p.UpgradeInsecureRequests {
+			ctx.URL.Scheme = "https"
+		}
+
+		checkReports, err := p.check("form-action", ctx)
+		if err != nil {
+			err2 = err
+			return false
+		}
+		suffix := ""
+		if _, ok := s.Attr(attrName); ok {
+			suffix = "[" + attrName + "]"
+		}
+		setPosition(checkReports, doc.position(node, suffix))
+		reports = append(reports, checkReports...)
+		return true
+	})
+	if err2 != nil {
+		return nil, err2
+	}
+	return reports, nil
+}
+
+// validateInlineAttributes checks inline event handler attributes such as
+// onclick, javascript: URLs in links and inline style attributes.
+func validateInlineAttributes(p Policy, page url.URL, doc *document, include func(*html.Node) bool) ([]Report, error) {
+	var reports []Report
+	var err2 error
+	doc.Find("*").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		node := s.Nodes[0]
+		if include != nil && !include(node) {
+			return true
+		}
+
+		elementName := strings.ToLower(node.Data)
+		for _, attr := range node.Attr {
+			var directiveName, body string
+			var script bool
+			if strings.HasPrefix(strings.ToLower(attr.Key), "on") {
+				directiveName = "script-src-attr"
+				body = attr.Val
+				script = true
+			} else if attr.Key == "href" && htmlNavigationElements[elementName] && isJavaScriptURL(attr.Val) {
+				// Navigating to a javascript: URL is checked like a script
+				// element and the hash covers the whole URL.
+				directiveName = "script-src-elem"
+				body = strings.TrimSpace(attr.Val)
+				script = true
+			} else if attr.Key == "style" {
+				directiveName = "style-src-attr"
+				body = attr.Val
+			} else {
+				continue
+			}
+
+			ctx := SourceContext{
+				Page:         page,
+				Body:         []byte(body),
+				UnsafeInline: true,
+				Script:       script,
+				Attribute:    true,
+			}
+			checkReports, err := p.check(directiveName, ctx)
+			if err != nil {
+				err2 = err
+				return false
+			}
+			pos := doc.position(node, "["+attr.Key+"]")
+			setPosition(checkReports, pos)
+			reports = append(reports, checkReports...)
+
+			if attr.Key == "style" {
+				reportsCSS, err := validateStyleAttribute(p, page, attr.Val)
+				if err != nil {
+					err2 = err
+					return false
+				}
+				setPosition(reportsCSS, pos)
+				reports = append(reports, reportsCSS...)
+			}
+		}
+		return true
+	})
+	if err2 != nil {
+		return nil, err2
+	}
+	return reports, nil
+}
+
+func isJavaScriptURL(u string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(u)), "javascript:")
 }
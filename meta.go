@@ -0,0 +1,90 @@
+package csp
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// metaIgnoredDirectives are the directives that browsers ignore when a policy
+// is delivered with a <meta> element.
+var metaIgnoredDirectives = map[string]bool{
+	"frame-ancestors": true,
+	"report-uri":      true,
+	"sandbox":         true,
+}
+
+// metaPolicy is a policy declared by a <meta> element in the page.
+type metaPolicy struct {
+	policy Policy
+	// include returns whether a node is affected by the policy.
+	include func(*html.Node) bool
+}
+
+// ParseMetaPolicy parses a policy delivered with a <meta> element, ignoring
+// the directives that aren't supported in that context.
+func ParseMetaPolicy(content string) (Policy, error) {
+	var directives []string
+	for _, directive := range strings.Split(content, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 || metaIgnoredDirectives[fields[0]] {
+			continue
+		}
+		directives = append(directives, directive)
+	}
+	if len(directives) == 0 {
+		return Policy{Directives: map[string]Directive{}}, nil
+	}
+	return ParsePolicy(strings.Join(directives, ";"))
+}
+
+// findMetaPolicies returns the policies declared by
+// <meta http-equiv="Content-Security-Policy"> elements in the head of doc.
+func findMetaPolicies(doc *goquery.Document) ([]metaPolicy, error) {
+	var order map[*html.Node]int
+	var policies []metaPolicy
+	var err2 error
+	doc.Find("head > meta[http-equiv]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if !strings.EqualFold(strings.TrimSpace(s.AttrOr("http-equiv", "")), "Content-Security-Policy") {
+			return true
+		}
+		content := s.AttrOr("content", "")
+		p, err := ParseMetaPolicy(content)
+		if err != nil {
+			err2 = errors.Wrapf(err, "meta policy %q", content)
+			return false
+		}
+
+		if order == nil {
+			order = documentOrder(doc.Nodes[0])
+		}
+		pos := order[s.Nodes[0]]
+		policies = append(policies, metaPolicy{
+			policy: p,
+			include: func(n *html.Node) bool {
+				return order[n] > pos
+			},
+		})
+		return true
+	})
+	if err2 != nil {
+		return nil, err2
+	}
+	return policies, nil
+}
+
+// documentOrder returns the position of every node under root in tree order.
+func documentOrder(root *html.Node) map[*html.Node]int {
+	order := map[*html.Node]int{}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		order[n] = len(order)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	return order
+}
@@ -0,0 +1,148 @@
+package csp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type nonceKey struct{}
+
+// NonceFromContext returns the nonce generated by Middleware for the request.
+// Templates should add it to the nonce attribute of inline scripts and styles.
+func NonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceKey{}).(string)
+	return nonce
+}
+
+// GenerateNonce returns a cryptographically random nonce.
+func GenerateNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// Middleware sets the Content-Security-Policy header of responses using a new
+// nonce for every request.
+type Middleware struct {
+	// Policy returns the policy for a request given its nonce.
+	Policy func(nonce string) (Policy, error)
+	// ReportOnly sends the policy with the Content-Security-Policy-Report-Only
+	// header instead.
+	ReportOnly bool
+	// Validate buffers HTML responses and logs the violations found by
+	// ValidatePage. This is intended for development.
+	Validate bool
+	// Logf is used to log violations and errors. It defaults to log.Printf.
+	Logf func(format string, args ...interface{})
+}
+
+func (m Middleware) logf(format string, args ...interface{}) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// Handler wraps next so its responses have a policy.
+func (m Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := GenerateNonce()
+		if err != nil {
+			m.logf("csp: generating nonce: %+v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		p, err := m.Policy(nonce)
+		if err != nil {
+			m.logf("csp: building policy: %+v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		header := "Content-Security-Policy"
+		if m.ReportOnly {
+			header = "Content-Security-Policy-Report-Only"
+			p.Disposition = DispositionReport
+		}
+		w.Header().Set(header, p.String())
+
+		r = r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce))
+		if !m.Validate {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bufferedResponseWriter{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		next.ServeHTTP(buf, r)
+		body := buf.body.Bytes()
+
+		contentType := w.Header().Get("Content-Type")
+		if len(contentType) == 0 {
+			contentType = http.DetectContentType(body)
+		}
+		if strings.HasPrefix(contentType, "text/html") {
+			m.validate(p, requestURL(r), body)
+		}
+
+		w.WriteHeader(buf.status)
+		if _, err := w.Write(body); err != nil {
+			m.logf("csp: writing response: %+v", err)
+		}
+	})
+}
+
+// validate logs all the violations of the policy in the page.
+func (m Middleware) validate(p Policy, page url.URL, body []byte) {
+	_, reports, err := ValidatePage(p, page, bytes.NewReader(body))
+	if err != nil {
+		m.logf("csp: validating %s: %+v", page.String(), err)
+		return
+	}
+	for _, r := range reports {
+		blocked := r.Blocked
+		if len(blocked) == 0 {
+			blocked = "inline"
+		}
+		m.logf("csp: %s: %s blocked by %s", r.Document, blocked, r.DirectiveName)
+	}
+}
+
+// requestURL returns the absolute URL of a server request.
+func requestURL(r *http.Request) url.URL {
+	u := *r.URL
+	u.Host = r.Host
+	u.Scheme = "http"
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	return u
+}
+
+// bufferedResponseWriter buffers the status and body of a response so it can
+// be validated before it's sent.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
@@ -0,0 +1,82 @@
+package csp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var logs []string
+	m := Middleware{
+		Policy: func(nonce string) (Policy, error) {
+			return NewPolicy().DefaultSrc(Self).ScriptSrc(Nonce(nonce)).Build()
+		},
+		Validate: true,
+		Logf: func(format string, args ...interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			logs = append(logs, fmt.Sprintf(format, args...))
+		},
+	}
+	var nonces []string
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := NonceFromContext(r.Context())
+		nonces = append(nonces, nonce)
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprintf(w, `<script nonce=%q>good()</script><script>bad()</script>`, nonce)
+	}))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "https://example.com/foo", nil))
+
+		if w.Code != http.StatusTeapot {
+			t.Errorf("status = %d; not %d", w.Code, http.StatusTeapot)
+		}
+		if !strings.Contains(w.Body.String(), "good()") {
+			t.Errorf("expected body to be written; got %q", w.Body.String())
+		}
+		header := w.Header().Get("Content-Security-Policy")
+		want := fmt.Sprintf("default-src 'self'; script-src 'nonce-%s'", nonces[i])
+		if header != want {
+			t.Errorf("Content-Security-Policy = %q; not %q", header, want)
+		}
+	}
+
+	if len(nonces[0]) == 0 || nonces[0] == nonces[1] {
+		t.Errorf("expected unique nonces; got %q", nonces)
+	}
+	if len(logs) != 2 || !strings.Contains(logs[0], "https://example.com/foo") {
+		t.Errorf("expected a violation to be logged for each request; got %q", logs)
+	}
+}
+
+func TestMiddlewareReportOnly(t *testing.T) {
+	t.Parallel()
+
+	m := Middleware{
+		Policy: func(nonce string) (Policy, error) {
+			return NewPolicy().ScriptSrc(Nonce(nonce)).Build()
+		},
+		ReportOnly: true,
+	}
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("Content-Security-Policy-Report-Only") == "" || w.Header().Get("Content-Security-Policy") != "" {
+		t.Errorf("expected only a report-only policy; got %+v", w.Header())
+	}
+	if w.Body.String() != "ok" {
+		t.Errorf("body = %q; not %q", w.Body.String(), "ok")
+	}
+}
@@ -0,0 +1,182 @@
+package csp
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aymerick/douceur/css"
+	"github.com/aymerick/douceur/parser"
+)
+
+// PolicySet is a list of policies that all apply to the same document. As
+// required by CSP3, a resource is only allowed if every policy in the set
+// allows it.
+type PolicySet []Policy
+
+// ParsePolicySet parses a serialized CSP header value. Multiple policies may be
+// specified by separating them with commas.
+func ParsePolicySet(header string) (PolicySet, error) {
+	var ps PolicySet
+	for _, policy := range strings.Split(header, ",") {
+		// Trailing semicolons are commonly sent by servers but would otherwise
+		// be parsed as an empty directive.
+		policy = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(policy), ";"))
+		if len(policy) == 0 {
+			continue
+		}
+		p, err := ParsePolicy(policy)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// ParseHeader parses all of the Content-Security-Policy and
+// Content-Security-Policy-Report-Only headers in h.
+func ParseHeader(h http.Header) (PolicySet, error) {
+	var ps PolicySet
+	headers := []struct {
+		name        string
+		disposition Disposition
+	}{
+		{"Content-Security-Policy", DispositionEnforce},
+		{"Content-Security-Policy-Report-Only", DispositionReport},
+	}
+	for _, header := range headers {
+		for _, value := range h[header.name] {
+			policies, err := ParsePolicySet(value)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range policies {
+				p.Disposition = header.disposition
+				ps = append(ps, p)
+			}
+		}
+	}
+	return ps, nil
+}
+
+// String returns the policies serialized as a single header value. The
+// disposition of the policies isn't included.
+func (ps PolicySet) String() string {
+	policies := make([]string, len(ps))
+	for i, p := range ps {
+		policies[i] = p.String()
+	}
+	return strings.Join(policies, ", ")
+}
+
+// isValid returns whether none of the reports are from enforced policies.
+func isValid(reports []Report) bool {
+	enforced, _ := SplitReports(reports)
+	return len(enforced) == 0
+}
+
+// ValidatePage checks that an HTML page passes every policy in the set. The
+// page is valid if there are no violations of enforced policies, but the
+// returned reports include violations of report-only policies too. Use
+// SplitReports to separate them.
+//
+// Policies declared by the page with <meta http-equiv="Content-Security-Policy">
+// are also enforced on the elements that come after them.
+func (ps PolicySet) ValidatePage(page url.URL, html io.Reader) (bool, []Report, error) {
+	doc, err := newDocument(html)
+	if err != nil {
+		return false, nil, err
+	}
+	reports, _, err := ps.validatePage(page, doc)
+	if err != nil {
+		return false, nil, err
+	}
+	return isValid(reports), reports, nil
+}
+
+// validatePage returns the violations of every policy in the set and of the
+// policies declared by doc, which are also returned.
+func (ps PolicySet) validatePage(page url.URL, doc *document) ([]Report, []metaPolicy, error) {
+	ps = ps.withSandbox()
+	var reports []Report
+	for _, p := range ps {
+		policyReports, err := validateDocument(p, page, doc, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		reports = append(reports, policyReports...)
+	}
+
+	metaPolicies, err := findMetaPolicies(doc.Document)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Meta policies can't sandbox the page, but they're affected by the
+	// sandbox of the other policies.
+	if d, ok := ps.Sandbox(); ok {
+		for i := range metaPolicies {
+			metaPolicies[i].policy.sandbox = &d
+		}
+	}
+	for _, meta := range metaPolicies {
+		policyReports, err := validateDocument(meta.policy, page, doc, meta.include)
+		if err != nil {
+			return nil, nil, err
+		}
+		reports = append(reports, policyReports...)
+	}
+	return reports, metaPolicies, nil
+}
+
+// ValidateStylesheet validates a stylesheet against every policy in the set.
+// Like ValidatePage, only enforced policies affect validity.
+func (ps PolicySet) ValidateStylesheet(page url.URL, css string) (bool, []Report, error) {
+	stylesheet, err := parser.Parse(css)
+	if err != nil {
+		return false, nil, err
+	}
+	reports, err := ps.validateStylesheet(page, page, stylesheet, css)
+	if err != nil {
+		return false, nil, err
+	}
+	return isValid(reports), reports, nil
+}
+
+// validateStylesheet returns the violations of every policy in the set in
+// stylesheet, resolving relative URLs against base.
+func (ps PolicySet) validateStylesheet(page, base url.URL, stylesheet *css.Stylesheet, source string) ([]Report, error) {
+	ps = ps.withSandbox()
+	var reports []Report
+	for _, p := range ps {
+		policyReports, err := validateStylesheet(p, page, base, stylesheet, source)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, policyReports...)
+	}
+	return reports, nil
+}
+
+// allows returns whether every enforced policy in the set allows ctx to be
+// loaded, including its require-sri-for directive.
+func (ps PolicySet) allows(directiveName string, ctx SourceContext) (bool, error) {
+	for _, p := range ps {
+		if p.Disposition == DispositionReport {
+			continue
+		}
+		reports, err := p.check(directiveName, ctx)
+		if err != nil {
+			return false, err
+		}
+		sriReports, err := checkIntegrity(p, ctx)
+		if err != nil {
+			return false, err
+		}
+		if len(reports) > 0 || len(sriReports) > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
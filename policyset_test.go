@@ -0,0 +1,154 @@
+package csp
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParsePolicySet(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		header string
+		count  int
+		err    string
+	}{
+		{"default-src 'self'", 1, ""},
+		{"default-src 'self', script-src 'none'", 2, ""},
+		{"default-src 'self';, script-src 'none';", 2, ""},
+		{"default-src 'self', , ", 1, ""},
+		{"", 0, ""},
+		{"default-src 'self', foo-src 'none'", 0, "unknown directive"},
+	}
+
+	for i, c := range cases {
+		ps, err := ParsePolicySet(c.header)
+		checkErr(t, err, c.err)
+		if len(ps) != c.count {
+			t.Errorf("%d. ParsePolicySet(%q) = %d policies; not %d", i, c.header, len(ps), c.count)
+		}
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	t.Parallel()
+
+	h := http.Header{}
+	h.Add("Content-Security-Policy", "default-src 'self'")
+	h.Add("Content-Security-Policy", "script-src 'none', img-src *")
+	ps, err := ParseHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 3 {
+		t.Fatalf("ParseHeader(%+v) = %d policies; not 3", h, len(ps))
+	}
+}
+
+func TestPolicySetValidate(t *testing.T) {
+	t.Parallel()
+
+	cases := []testCase{
+		{
+			name:   "allowed by all policies",
+			policy: "default-src 'self', script-src https://google.com",
+			page:   "https://google.com",
+			html:   `<script src="https://google.com"></script>`,
+			valid:  true,
+		},
+		{
+			name:   "blocked by second policy",
+			policy: "default-src *, script-src 'none'",
+			page:   "https://google.com",
+			html:   `<script src="https://google.com"></script>`,
+			valid:  false,
+		},
+		{
+			name:   "blocked by first policy",
+			policy: "img-src 'none', default-src *",
+			page:   "https://google.com",
+			html:   `<img src="https://google.com/foo.png">`,
+			valid:  false,
+		},
+		{
+			name:   "stylesheet imports",
+			policy: "style-src 'unsafe-inline' 'self', style-src 'unsafe-inline'",
+			page:   "https://google.com",
+			html:   `<style>@import url('blah.css')</style>`,
+			valid:  false,
+		},
+	}
+
+	for i, c := range cases {
+		i := i
+		c := c
+		t.Run(fmt.Sprintf("%d.%s", i, c.name), func(t *testing.T) {
+			t.Parallel()
+
+			ps, err := ParsePolicySet(c.policy)
+			checkErr(t, err, c.policyErr)
+			page, err := url.Parse(c.page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			valid, reports, err := ps.ValidatePage(*page, strings.NewReader(c.html))
+			checkErr(t, err, c.validateErr)
+			if valid != c.valid {
+				t.Errorf("ValidatePage(...) = %v; not %v; reports = %+v", valid, c.valid, reports)
+			}
+		})
+	}
+}
+
+func TestReportOnly(t *testing.T) {
+	t.Parallel()
+
+	h := http.Header{}
+	h.Add("Content-Security-Policy", "img-src *")
+	h.Add("Content-Security-Policy-Report-Only", "script-src 'none'")
+	ps, err := ParseHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 || ps[0].Disposition != DispositionEnforce || ps[1].Disposition != DispositionReport {
+		t.Fatalf("ParseHeader(%+v) = %+v; expected one enforced and one report-only policy", h, ps)
+	}
+
+	page, err := url.Parse("https://google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, reports, err := ps.ValidatePage(*page, strings.NewReader(`
+		<img src="https://foo.com/bar.png">
+		<script src="https://google.com/foo.js"></script>
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid {
+		t.Errorf("expected page to be valid; reports = %+v", reports)
+	}
+	enforced, reportOnly := SplitReports(reports)
+	if len(enforced) != 0 || len(reportOnly) != 1 {
+		t.Fatalf("SplitReports(%+v) = %+v, %+v; expected 1 report-only violation", reports, enforced, reportOnly)
+	}
+	if got := reportOnly[0].Disposition.String(); got != "report" {
+		t.Errorf("Disposition = %q; not %q", got, "report")
+	}
+}
+
+func TestPolicySetString(t *testing.T) {
+	t.Parallel()
+
+	header := "script-src 'self', img-src 'none'"
+	ps, err := ParsePolicySet(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ps.String(); got != header {
+		t.Errorf("ParsePolicySet(%q).String() = %q", header, got)
+	}
+}
@@ -0,0 +1,222 @@
+package csp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Position is the location of a violation in the validated HTML or CSS.
+type Position struct {
+	// Line and Column are 1-based. They're 0 if the location is unknown.
+	Line, Column int
+	// Path identifies the offending element, attribute or CSS rule, such as
+	// "html > body > div#main[onclick]" or "@media screen > .foo".
+	Path string
+}
+
+// String returns the position as line:column followed by the path.
+func (p Position) String() string {
+	var parts []string
+	if p.Line > 0 {
+		parts = append(parts, fmt.Sprintf("%d:%d", p.Line, p.Column))
+	}
+	if len(p.Path) > 0 {
+		parts = append(parts, p.Path)
+	}
+	return strings.Join(parts, " ")
+}
+
+// lineIndex converts byte offsets in a source to line and column positions.
+type lineIndex struct {
+	source string
+	// starts are the offsets of the start of each line.
+	starts []int
+}
+
+func newLineIndex(source string) lineIndex {
+	starts := []int{0}
+	for i := 0; i < len(source); i++ {
+		if source[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return lineIndex{source: source, starts: starts}
+}
+
+// position returns the position of the byte offset.
+func (l lineIndex) position(off int) Position {
+	if off > len(l.source) {
+		off = len(l.source)
+	}
+	line := sort.Search(len(l.starts), func(i int) bool {
+		return l.starts[i] > off
+	})
+	start := l.starts[line-1]
+	return Position{
+		Line:   line,
+		Column: utf8.RuneCountInString(l.source[start:off]) + 1,
+	}
+}
+
+// setPosition sets the position of all the reports.
+func setPosition(reports []Report, pos Position) {
+	for i := range reports {
+		reports[i].Position = pos
+	}
+}
+
+// nodePosition is the location of an element in an HTML document.
+type nodePosition struct {
+	// start is the position of the start tag.
+	start Position
+	// content is the position right after the start tag.
+	content Position
+}
+
+// document is an HTML document with the source positions of its elements.
+type document struct {
+	*goquery.Document
+
+	positions map[*html.Node]nodePosition
+}
+
+// newDocument parses an HTML document and locates its elements in the source.
+func newDocument(r io.Reader) (*document, error) {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	return &document{
+		Document:  doc,
+		positions: htmlPositions(string(source), doc.Nodes[0]),
+	}, nil
+}
+
+// position returns the position of the node with the provided suffix, such as
+// an attribute selector, added to its path.
+func (d *document) position(n *html.Node, suffix string) Position {
+	pos := d.positions[n].start
+	pos.Path = elementPath(n) + suffix
+	return pos
+}
+
+// locateContent converts the positions of reports from the text content of the
+// node, such as an inline stylesheet, to positions in the document.
+func (d *document) locateContent(reports []Report, n *html.Node) {
+	content := d.positions[n].content
+	path := elementPath(n)
+	for i := range reports {
+		pos := &reports[i].Position
+		if content.Line > 0 && pos.Line > 0 {
+			if pos.Line == 1 {
+				pos.Column += content.Column - 1
+			}
+			pos.Line += content.Line - 1
+		} else {
+			pos.Line, pos.Column = content.Line, content.Column
+		}
+		if len(pos.Path) > 0 {
+			pos.Path = path + " > " + pos.Path
+		} else {
+			pos.Path = path
+		}
+	}
+}
+
+// elementPath returns a path of element names from the root of the document
+// to n, such as "html > body > div#main".
+func elementPath(n *html.Node) string {
+	var parts []string
+	for ; n != nil; n = n.Parent {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		part := n.Data
+		for _, attr := range n.Attr {
+			if attr.Key == "id" && len(attr.Val) > 0 {
+				part += "#" + attr.Val
+			}
+		}
+		parts = append(parts, part)
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, " > ")
+}
+
+// impliedElements are the elements the parser creates even if there is no
+// start tag for them.
+var impliedElements = map[string]bool{
+	"html":     true,
+	"head":     true,
+	"body":     true,
+	"tbody":    true,
+	"colgroup": true,
+}
+
+// htmlPositions matches the elements under root to the start tags in source.
+// The parser doesn't keep track of positions, so the source is tokenized again
+// and elements are matched to start tags with the same name in document order.
+func htmlPositions(source string, root *html.Node) map[*html.Node]nodePosition {
+	type startTag struct {
+		name       string
+		start, end int
+	}
+	var tags []startTag
+	z := html.NewTokenizer(strings.NewReader(source))
+	off := 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := len(z.Raw())
+		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+			name, _ := z.TagName()
+			tags = append(tags, startTag{string(name), off, off + raw})
+		}
+		off += raw
+	}
+
+	lines := newLineIndex(source)
+	positions := map[*html.Node]nodePosition{}
+	next := 0
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for i := next; i < len(tags); i++ {
+				if tags[i].name == n.Data {
+					positions[n] = nodePosition{
+						start:   lines.position(tags[i].start),
+						content: lines.position(tags[i].end),
+					}
+					next = i + 1
+					break
+				}
+				// Implied elements only match a start tag at the current
+				// position so they don't skip over the rest of the tags.
+				if impliedElements[n.Data] {
+					break
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	return positions
+}
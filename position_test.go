@@ -0,0 +1,108 @@
+package csp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReportPositions(t *testing.T) {
+	t.Parallel()
+
+	page, err := url.Parse("https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePolicy("default-src 'self'; style-src 'self' 'unsafe-inline'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		html string
+		want []Position
+	}{
+		{
+			"<script>alert(1)</script>",
+			[]Position{{1, 1, "html > head > script"}},
+		},
+		{
+			"<p>\n  <img id=\"logo\" src=\"https://evil.com/a.png\">\n</p>",
+			[]Position{{2, 3, "html > body > p > img#logo"}},
+		},
+		{
+			"<ul>\n<li>a</li>\n<li><a href=\"#\" onclick=\"foo()\">b</a></li>\n</ul>",
+			[]Position{{3, 5, "html > body > ul > li > a[onclick]"}},
+		},
+		{
+			"<div style=\"color: red; background: url(https://evil.com/a.png)\"></div>",
+			[]Position{{1, 1, "html > body > div[style]"}},
+		},
+		{
+			"<body>\n<style>\n.a { color: red; }\n@media screen {\n  .b { background: url(https://evil.com/b.png); }\n}\n</style>",
+			[]Position{{5, 24, "html > body > style > @media screen > .b"}},
+		},
+		{
+			"<style>.a { background: url(https://evil.com/a.png) }</style>",
+			[]Position{{1, 29, "html > head > style > .a"}},
+		},
+		{
+			"<table><tr><td><img src=\"https://evil.com/a.png\"></td></tr></table>",
+			[]Position{{1, 16, "html > body > table > tbody > tr > td > img"}},
+		},
+	}
+
+	for i, c := range cases {
+		_, reports, err := ValidatePage(p, *page, strings.NewReader(c.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != len(c.want) {
+			t.Errorf("%d. ValidatePage(%q) = %+v; expected %d reports", i, c.html, reports, len(c.want))
+			continue
+		}
+		for j, r := range reports {
+			if r.Position != c.want[j] {
+				t.Errorf("%d. ValidatePage(%q) position = %+v; not %+v", i, c.html, r.Position, c.want[j])
+			}
+		}
+	}
+}
+
+func TestStylesheetPositions(t *testing.T) {
+	t.Parallel()
+
+	page, err := url.Parse("https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePolicy("default-src 'self'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	css := `@import url("https://evil.com/a.css");
+a { background: url(/ok.png); }
+a, b {
+  background: url(https://evil.com/a.png);
+}
+@font-face { src: url(https://evil.com/a.woff); }
+`
+	want := []Position{
+		{1, 14, "@import"},
+		{4, 19, "a, b"},
+		{6, 23, "@font-face"},
+	}
+	_, reports, err := ValidateStylesheet(p, *page, css)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != len(want) {
+		t.Fatalf("ValidateStylesheet() = %+v; expected %d reports", reports, len(want))
+	}
+	for i, r := range reports {
+		if r.Position != want[i] {
+			t.Errorf("%d. position = %+v; not %+v", i, r.Position, want[i])
+		}
+	}
+}
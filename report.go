@@ -0,0 +1,94 @@
+package csp
+
+import (
+	"encoding/json"
+)
+
+// maxSampleLength is the maximum number of characters in a script-sample.
+const maxSampleLength = 40
+
+// ViolationReport converts the report to the violation report a browser would
+// send. ValidatePage doesn't know the response status of the page, so pages
+// loaded over HTTP are assumed to have succeeded.
+func (r Report) ViolationReport() ViolationReport {
+	v := ViolationReport{
+		DocumentURI:        r.Document,
+		BlockedURI:         r.blockedURI(),
+		ViolatedDirective:  r.EffectiveDirective,
+		EffectiveDirective: r.EffectiveDirective,
+		OriginalPolicy:     r.OriginalPolicy,
+		Disposition:        r.Disposition.String(),
+		SourceFile:         r.Document,
+		ScriptSample:       r.sample(),
+		LineNumber:         r.Position.Line,
+		ColumnNumber:       r.Position.Column,
+	}
+	if len(r.SourceFile) > 0 {
+		v.SourceFile = r.SourceFile
+	}
+	if scheme := r.Context.Page.Scheme; scheme == "http" || scheme == "https" {
+		v.StatusCode = 200
+	}
+	return v
+}
+
+// blockedURI returns the blocked URI as sent in reports which uses keywords
+// for inline content and eval.
+func (r Report) blockedURI() string {
+	if len(r.Blocked) > 0 {
+		return r.Blocked
+	}
+	if r.Context.UnsafeEval {
+		return "eval"
+	}
+	return "inline"
+}
+
+// sample returns the start of the blocked inline content if the directive
+// has 'report-sample'.
+func (r Report) sample() string {
+	d, ok := r.Directive.(SourceDirective)
+	if !ok || !d.ReportSample || !r.Context.UnsafeInline {
+		return ""
+	}
+	sample := []rune(string(r.Context.Body))
+	if len(sample) > maxSampleLength {
+		sample = sample[:maxSampleLength]
+	}
+	return string(sample)
+}
+
+// ReportingAPIReport converts the report to the csp-violation report a
+// browser would send to a report-to endpoint.
+func (r Report) ReportingAPIReport() ReportingAPIReport {
+	v := r.ViolationReport()
+	return ReportingAPIReport{
+		Type: reportingAPIViolationType,
+		URL:  v.DocumentURI,
+		Body: ReportingAPIBody{
+			DocumentURL:        v.DocumentURI,
+			Referrer:           v.Referrer,
+			BlockedURL:         v.BlockedURI,
+			EffectiveDirective: v.EffectiveDirective,
+			OriginalPolicy:     v.OriginalPolicy,
+			SourceFile:         v.SourceFile,
+			Sample:             v.ScriptSample,
+			Disposition:        v.Disposition,
+			StatusCode:         v.StatusCode,
+			LineNumber:         v.LineNumber,
+			ColumnNumber:       v.ColumnNumber,
+		},
+	}
+}
+
+// MarshalCSPReport returns the application/csp-report body a browser would
+// send to a report-uri endpoint.
+func (r Report) MarshalCSPReport() ([]byte, error) {
+	return json.Marshal(legacyReport{CSPReport: r.ViolationReport()})
+}
+
+// MarshalReportingAPI returns the application/reports+json body a browser
+// would send to a report-to endpoint.
+func (r Report) MarshalReportingAPI() ([]byte, error) {
+	return json.Marshal([]ReportingAPIReport{r.ReportingAPIReport()})
+}
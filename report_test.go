@@ -0,0 +1,96 @@
+package csp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReportViolationReport(t *testing.T) {
+	t.Parallel()
+
+	// The original policy is reported as it was delivered, not serialized.
+	policy := "script-src 'report-sample'  'self'; default-src 'self'"
+	p, err := ParsePolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Disposition = DispositionReport
+	page, err := url.Parse("https://example.com/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, reports, err := ValidatePage(p, *page, strings.NewReader(`
+		<script>`+strings.Repeat("a", 50)+`</script>
+		<img src="https://evil.com/foo.png">
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 reports; got %+v", reports)
+	}
+
+	want := map[string]ViolationReport{
+		"script-src-elem": {
+			DocumentURI:        "https://example.com/foo",
+			BlockedURI:         "inline",
+			ViolatedDirective:  "script-src-elem",
+			EffectiveDirective: "script-src-elem",
+			OriginalPolicy:     policy,
+			Disposition:        "report",
+			StatusCode:         200,
+			SourceFile:         "https://example.com/foo",
+			LineNumber:         2,
+			ColumnNumber:       3,
+			ScriptSample:       strings.Repeat("a", 40),
+		},
+		"img-src": {
+			DocumentURI:        "https://example.com/foo",
+			BlockedURI:         "https://evil.com/foo.png",
+			ViolatedDirective:  "img-src",
+			EffectiveDirective: "img-src",
+			OriginalPolicy:     policy,
+			Disposition:        "report",
+			StatusCode:         200,
+			SourceFile:         "https://example.com/foo",
+			LineNumber:         3,
+			ColumnNumber:       3,
+		},
+	}
+	for _, r := range reports {
+		w, ok := want[r.EffectiveDirective]
+		if !ok {
+			t.Fatalf("unexpected report %+v", r)
+		}
+		if got := r.ViolationReport(); got != w {
+			t.Errorf("ViolationReport() = %+v; not %+v", got, w)
+		}
+
+		// Replaying the reports through the collector should give the same
+		// result.
+		body, err := r.MarshalCSPReport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseViolationReports("application/csp-report", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parsed) != 1 || parsed[0] != w {
+			t.Errorf("ParseViolationReports(%s) = %+v; not %+v", body, parsed, w)
+		}
+
+		body, err = r.MarshalReportingAPI()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err = ParseViolationReports("application/reports+json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parsed) != 1 || parsed[0] != w {
+			t.Errorf("ParseViolationReports(%s) = %+v; not %+v", body, parsed, w)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package csp
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// sandboxFlags are the tokens allowed in the sandbox directive. Each one lifts
+// one of the restrictions of a sandboxed document.
+var sandboxFlags = map[string]bool{
+	"allow-downloads":                          true,
+	"allow-forms":                              true,
+	"allow-modals":                             true,
+	"allow-orientation-lock":                   true,
+	"allow-pointer-lock":                       true,
+	"allow-popups":                             true,
+	"allow-popups-to-escape-sandbox":           true,
+	"allow-presentation":                       true,
+	"allow-same-origin":                        true,
+	"allow-scripts":                            true,
+	"allow-storage-access-by-user-activation":  true,
+	"allow-top-navigation":                     true,
+	"allow-top-navigation-by-user-activation":  true,
+	"allow-top-navigation-to-custom-protocols": true,
+}
+
+// SandboxDirective applies the same restrictions to the page as the sandbox
+// attribute of an iframe.
+type SandboxDirective struct {
+	// Flags are the allow-* tokens of the directive.
+	Flags map[string]bool
+}
+
+// ParseSandboxDirective parses the tokens of a sandbox directive. Like in
+// browsers, unknown tokens are ignored.
+func ParseSandboxDirective(tokens []string) (SandboxDirective, error) {
+	d := SandboxDirective{
+		Flags: map[string]bool{},
+	}
+	for _, token := range tokens {
+		token = strings.ToLower(token)
+		if sandboxFlags[token] {
+			d.Flags[token] = true
+		}
+	}
+	return d, nil
+}
+
+// Allows returns whether the flag, such as allow-scripts, is set.
+func (d SandboxDirective) Allows(flag string) bool {
+	return d.Flags[flag]
+}
+
+// Check implements Directive. Scripts are blocked without allow-scripts and
+// form submissions without allow-forms.
+func (d SandboxDirective) Check(p Policy, ctx SourceContext) (bool, error) {
+	if ctx.Script && !d.Allows("allow-scripts") {
+		return false, nil
+	}
+	if ctx.Form && !d.Allows("allow-forms") {
+		return false, nil
+	}
+	return true, nil
+}
+
+// String returns the flags of the directive.
+func (d SandboxDirective) String() string {
+	var flags []string
+	for flag := range d.Flags {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+	return strings.Join(flags, " ")
+}
+
+// Sandbox returns the sandbox directive of the policy and whether the page is
+// sandboxed. Browsers ignore sandbox in report-only policies, so they're never
+// sandboxed.
+func (p Policy) Sandbox() (SandboxDirective, bool) {
+	d, ok := p.Directives["sandbox"].(SandboxDirective)
+	if !ok || p.Disposition == DispositionReport {
+		return SandboxDirective{}, false
+	}
+	return d, true
+}
+
+// Sandbox returns the sandbox of the document and whether it's sandboxed. A
+// flag is only set if every policy that sandboxes the document sets it.
+func (ps PolicySet) Sandbox() (SandboxDirective, bool) {
+	var sandbox SandboxDirective
+	var sandboxed bool
+	for _, p := range ps {
+		d, ok := p.Sandbox()
+		if !ok {
+			continue
+		}
+		if !sandboxed {
+			sandbox.Flags = map[string]bool{}
+			for flag := range d.Flags {
+				sandbox.Flags[flag] = true
+			}
+			sandboxed = true
+			continue
+		}
+		for flag := range sandbox.Flags {
+			if !d.Allows(flag) {
+				delete(sandbox.Flags, flag)
+			}
+		}
+	}
+	return sandbox, sandboxed
+}
+
+// withSandbox returns a copy of the set where every policy is checked with
+// the sandbox of the document, as a sandbox from any policy applies to the
+// whole document.
+func (ps PolicySet) withSandbox() PolicySet {
+	out := append(PolicySet{}, ps...)
+	if d, ok := ps.Sandbox(); ok {
+		for i := range out {
+			out[i].sandbox = &d
+		}
+	}
+	return out
+}
+
+// opaqueOrigin returns whether the page is sandboxed into a unique origin,
+// which 'self' never matches.
+func (p Policy) opaqueOrigin() bool {
+	d, ok := p.Sandbox()
+	if p.sandbox != nil {
+		d, ok = *p.sandbox, true
+	}
+	return ok && !d.Allows("allow-same-origin")
+}
+
+// validateSandbox checks the scripts, event handlers, javascript: links and
+// forms in doc against the sandbox flags of the policy.
+func validateSandbox(p Policy, page url.URL, doc *document, include func(*html.Node) bool) ([]Report, error) {
+	d, ok := p.Sandbox()
+	if !ok {
+		return nil, nil
+	}
+
+	var reports []Report
+	var err2 error
+	check := func(ctx SourceContext, pos Position) bool {
+		allowed, err := d.Check(p, ctx)
+		if err != nil {
+			err2 = err
+			return false
+		}
+		if !allowed {
+			r := p.report(ctx, "sandbox", d)
+			r.Position = pos
+			reports = append(reports, r)
+		}
+		return true
+	}
+	doc.Find("*").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		node := s.Nodes[0]
+		if include != nil && !include(node) {
+			return true
+		}
+
+		elementName := strings.ToLower(node.Data)
+		switch elementName {
+		case "script":
+			ctx := SourceContext{
+				Page:   page,
+				Script: true,
+			}
+			if src := s.AttrOr("src", ""); len(src) > 0 {
+				parsed, err := url.Parse(src)
+				if err != nil {
+					err2 = err
+					return false
+				}
+				ctx.URL = *page.ResolveReference(parsed)
+			} else {
+				ctx.Body = []byte(s.Text())
+				ctx.UnsafeInline = true
+			}
+			if !check(ctx, doc.position(node, "")) {
+				return false
+			}
+
+		case "form":
+			parsed, err := url.Parse(s.AttrOr("action", ""))
+			if err != nil {
+				err2 = err
+				return false
+			}
+			ctx := SourceContext{
+				Page: page,
+				URL:  *page.ResolveReference(parsed),
+				Form: true,
+			}
+			if !check(ctx, doc.position(node, "")) {
+				return false
+			}
+		}
+
+		for _, attr := range node.Attr {
+			isHandler := strings.HasPrefix(strings.ToLower(attr.Key), "on")
+			isLink := attr.Key == "href" && htmlNavigationElements[elementName] && isJavaScriptURL(attr.Val)
+			if !isHandler && !isLink {
+				continue
+			}
+			ctx := SourceContext{
+				Page:         page,
+				Body:         []byte(attr.Val),
+				UnsafeInline: true,
+				Script:       true,
+				Attribute:    true,
+			}
+			if !check(ctx, doc.position(node, "["+attr.Key+"]")) {
+				return false
+			}
+		}
+		return true
+	})
+	if err2 != nil {
+		return nil, err2
+	}
+	return reports, nil
+}
@@ -0,0 +1,85 @@
+package csp
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPolicySandbox(t *testing.T) {
+	t.Parallel()
+
+	h := http.Header{}
+	h.Add("Content-Security-Policy", "sandbox allow-scripts")
+	h.Add("Content-Security-Policy-Report-Only", "sandbox")
+	ps, err := ParseHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, ok := ps[0].Sandbox()
+	if !ok || !d.Allows("allow-scripts") || d.Allows("allow-forms") {
+		t.Errorf("Sandbox() = %+v, %v; expected allow-scripts", d, ok)
+	}
+	// Browsers ignore sandbox in report-only policies.
+	if d, ok := ps[1].Sandbox(); ok {
+		t.Errorf("Sandbox() = %+v, %v; expected report-only policy to not be sandboxed", d, ok)
+	}
+
+	page, err := url.Parse("https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, reports, err := ps.ValidatePage(*page, strings.NewReader(`
+		<script>foo()</script>
+		<form action="/submit"></form>
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report; got %+v", reports)
+	}
+	r := reports[0]
+	if r.DirectiveName != "sandbox" || r.Blocked != "https://example.com/submit" || r.Position.Path != "html > body > form" {
+		t.Errorf("unexpected report %+v", r)
+	}
+}
+
+func TestPolicySetSandbox(t *testing.T) {
+	t.Parallel()
+
+	page, err := url.Parse("https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		header string
+		valid  bool
+	}{
+		{"img-src 'self'", true},
+		// The sandbox of any policy makes the origin of the document opaque
+		// for every policy.
+		{"sandbox allow-scripts, img-src 'self'", false},
+		{"sandbox allow-same-origin, img-src 'self'", true},
+		{"sandbox allow-same-origin, sandbox allow-scripts, img-src 'self'", false},
+	}
+
+	for i, c := range cases {
+		h := http.Header{}
+		h.Add("Content-Security-Policy", c.header)
+		ps, err := ParseHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		valid, reports, err := ps.ValidatePage(*page, strings.NewReader(`<img src="/a.png">`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != c.valid {
+			t.Errorf("%d. ValidatePage(%q) = %v; not %v; reports = %+v", i, c.header, valid, c.valid, reports)
+		}
+	}
+}
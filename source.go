@@ -7,14 +7,18 @@ import (
 	"hash"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 
-	"github.com/gobwas/glob"
 	"github.com/pkg/errors"
 )
 
 var hostSchemeRegex = regexp.MustCompile(`((\w+|\*):(//)?)?(\*|\w+)(\.\w+)*(:(\d+|\*))?`)
 
+// base64ValueRegex matches the base64-value grammar used by nonce and hash
+// sources, which allows both base64 and base64url.
+var base64ValueRegex = regexp.MustCompile(`^[A-Za-z0-9+/_-]+={0,2}$`)
+
 // SourceContext is the context required by a CSP policy.
 type SourceContext struct {
 	URL          url.URL
@@ -23,25 +27,88 @@ type SourceContext struct {
 	UnsafeEval   bool
 	Nonce        string
 	Body         []byte
+	// Script is set when the context is a parser-inserted script, which makes
+	// it subject to 'strict-dynamic'.
+	Script bool
+	// Attribute is set when the context is inline content from an attribute
+	// such as an event handler or a javascript: URL. Hashes only allow it if
+	// 'unsafe-hashes' is present.
+	Attribute bool
+	// Form is set when the context is a form submission.
+	Form bool
+	// Style is set when the context is a stylesheet.
+	Style bool
+	// Integrity is the Subresource Integrity metadata of an external script or
+	// stylesheet.
+	Integrity []IntegrityMetadata
+	// Redirects are the URLs that redirected to URL, starting with the
+	// original request. Every request in the chain has to be allowed.
+	Redirects []url.URL
+}
+
+// hops returns a context for every request in the redirect chain of s, ending
+// with s itself. Each of them has the URLs before it as its Redirects.
+func (s SourceContext) hops() []SourceContext {
+	chain := append(append([]url.URL{}, s.Redirects...), s.URL)
+	hops := make([]SourceContext, len(chain))
+	for i, u := range chain {
+		hop := s
+		hop.URL = u
+		hop.Redirects = chain[:i]
+		hops[i] = hop
+	}
+	return hops
 }
 
 // Report contains information about a CSP violation.
 type Report struct {
-	Document      string
-	Blocked       string
+	Document string
+	Blocked  string
+	// DirectiveName is the name of the directive that was checked, such as
+	// script-src-elem, even if a fallback such as default-src blocked it.
 	DirectiveName string
-	Directive     Directive
-	Context       SourceContext
+	// EffectiveDirective is the name of the directive that was checked, as
+	// used in violation reports.
+	EffectiveDirective string
+	// ViolatedDirective is the name of the directive that blocked the
+	// resource. This may be a fallback of the effective directive such as
+	// default-src, or block-all-mixed-content.
+	ViolatedDirective string
+	Directive         Directive
+	Context           SourceContext
+	Disposition       Disposition
+	// OriginalPolicy is the violated policy as it was delivered.
+	OriginalPolicy string
+	// Position is where the violation is in the validated HTML or CSS.
+	Position Position
+	// SourceFile is the URL of the fetched stylesheet that contains the
+	// violation. It's empty for violations in the validated page itself.
+	SourceFile string
+}
+
+// SplitReports separates reports from enforced policies from the reports of
+// report-only policies.
+func SplitReports(reports []Report) (enforced, reportOnly []Report) {
+	for _, r := range reports {
+		if r.Disposition == DispositionReport {
+			reportOnly = append(reportOnly, r)
+		} else {
+			enforced = append(enforced, r)
+		}
+	}
+	return enforced, reportOnly
 }
 
 // Report returns a report with the specified parameters.
 func (s SourceContext) Report(name string, directive Directive) Report {
 	return Report{
-		Document:      s.Page.String(),
-		Blocked:       s.URL.String(),
-		DirectiveName: name,
-		Directive:     directive,
-		Context:       s,
+		Document:           s.Page.String(),
+		Blocked:            s.URL.String(),
+		DirectiveName:      name,
+		EffectiveDirective: name,
+		ViolatedDirective:  name,
+		Directive:          directive,
+		Context:            s,
 	}
 }
 
@@ -66,54 +133,70 @@ func ParseSourceDirective(sources []string) (SourceDirective, error) {
 type SourceDirective struct {
 	ruleCount int
 
-	None         bool
-	Nonces       map[string]bool
-	Hashes       []HashSource
-	UnsafeEval   bool
-	UnsafeInline bool
-	Self         bool
-	Schemes      map[string]bool
-	Hosts        []glob.Glob
+	None          bool
+	Nonces        map[string]bool
+	Hashes        []HashSource
+	UnsafeEval    bool
+	UnsafeInline  bool
+	UnsafeHashes  bool
+	StrictDynamic bool
+	Self          bool
+	ReportSample  bool
+	Schemes       map[string]bool
+	// Hosts are the host sources such as https://*.example.com:443/js/.
+	Hosts []HostSource
 }
 
-func urlSchemeHost(u url.URL) string {
-	u.Path = ""
-	u.RawPath = ""
-	u.RawQuery = ""
-	u.Fragment = ""
-	return u.String()
+// Check that the SourceContext is allowed for this SourceDirective. If the
+// context was redirected, every request in the redirect chain is checked.
+// block-all-mixed-content isn't part of the directive, so it's only enforced
+// by the checks of Policy such as ValidatePage.
+func (s SourceDirective) Check(p Policy, ctx SourceContext) (bool, error) {
+	for _, hop := range ctx.hops() {
+		allowed, err := s.checkHop(p, hop)
+		if err != nil || !allowed {
+			return false, err
+		}
+	}
+	return true, nil
 }
 
-// Check that the SourceContext is allowed for this SourceDirective.
-func (s SourceDirective) Check(p Policy, ctx SourceContext) (bool, error) {
+// checkHop checks a single request of a redirect chain.
+func (s SourceDirective) checkHop(p Policy, ctx SourceContext) (bool, error) {
 	if s.None {
 		return false, nil
 	}
 	if ctx.UnsafeEval && !s.UnsafeEval {
 		return false, nil
 	}
-	// Block all insecure requests if block-all-mixed-content is set.
-	if p.BlockAllMixedContent && ctx.Page.Scheme == "https" && ctx.URL.Scheme == "http" {
-		return false, nil
-	}
+
+	// With 'strict-dynamic' scripts are only allowed by nonces and hashes.
+	// Scripts loaded by trusted scripts aren't visible to static analysis, so
+	// every script is treated as parser-inserted.
+	strictDynamic := s.StrictDynamic && ctx.Script
 
 	var originAllow bool
 	isUnsafe := ctx.UnsafeInline
-	if ctx.UnsafeInline && len(s.Nonces) == 0 && s.UnsafeInline {
+	if ctx.UnsafeInline && len(s.Nonces) == 0 && s.UnsafeInline && !strictDynamic {
 		isUnsafe = false
 		originAllow = true
 	}
 
-	if s.Self && ctx.URL.Host == ctx.Page.Host && ctx.URL.Scheme == ctx.Page.Scheme {
+	if !strictDynamic && s.matchesURL(p, ctx.Page, ctx.URL, len(ctx.Redirects) > 0) {
 		originAllow = true
 	}
-	if s.Schemes[ctx.URL.Scheme] || s.Schemes["http"] && ctx.URL.Scheme == "https" {
-		originAllow = true
+	if ctx.Attribute && !s.UnsafeHashes {
+		return originAllow && !isUnsafe, nil
 	}
 	if s.Nonces[ctx.Nonce] {
 		originAllow = true
 		isUnsafe = false
 	}
+	// External scripts are allowed if all of their integrity metadata is
+	// listed as hash sources.
+	if ctx.Script && !ctx.UnsafeInline && s.matchesIntegrity(ctx) {
+		originAllow = true
+	}
 	for _, hash := range s.Hashes {
 		allow, err := hash.Check(ctx)
 		if err != nil {
@@ -124,21 +207,55 @@ func (s SourceDirective) Check(p Policy, ctx SourceContext) (bool, error) {
 			isUnsafe = false
 		}
 	}
-	srcHost := urlSchemeHost(ctx.URL)
+	return originAllow && !isUnsafe, nil
+}
+
+// matchesURL returns whether u is allowed by 'self', a scheme or a host source
+// when loaded by page. redirected is set if u was reached by a redirect.
+func (s SourceDirective) matchesURL(p Policy, page, u url.URL, redirected bool) bool {
+	if s.Self && !p.opaqueOrigin() && matchSelf(page, u) {
+		return true
+	}
+	return s.matchesSource(page, u, redirected)
+}
+
+// matchesSource returns whether u is allowed by a scheme or a host source when
+// loaded by page. The paths of host sources are ignored if skipPath is set.
+func (s SourceDirective) matchesSource(page, u url.URL, skipPath bool) bool {
+	for scheme := range s.Schemes {
+		if matchScheme(strings.ToLower(scheme), strings.ToLower(u.Scheme)) {
+			return true
+		}
+	}
 	for _, host := range s.Hosts {
-		if host.Match(srcHost) {
-			originAllow = true
+		if host.match(page, u, skipPath) {
+			return true
 		}
 	}
-	return originAllow && !isUnsafe, nil
+	return false
+}
+
+// hashAlgorithms are the hash algorithms supported by hash sources and
+// integrity metadata.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
 }
 
 // HashSource is a SourceDirective rule that matches the hash of content.
 type HashSource struct {
+	// Name is the name of the hash algorithm, such as sha256.
+	Name      string
 	Algorithm func() hash.Hash
 	Value     string
 }
 
+// String returns the hash source expression.
+func (s HashSource) String() string {
+	return "'" + s.Name + "-" + s.Value + "'"
+}
+
 // Check if the ctx hash matches this hash.
 func (s HashSource) Check(ctx SourceContext) (bool, error) {
 	h := s.Algorithm()
@@ -164,36 +281,37 @@ func (s *SourceDirective) ParseSource(source string) error {
 		case "'unsafe-eval'":
 			s.UnsafeEval = true
 			return nil
+		case "'unsafe-hashes'":
+			s.UnsafeHashes = true
+			return nil
 		case "'none'":
 			s.None = true
 			return nil
 		case "'strict-dynamic'":
-			// TODO: implement strict-dynamic
+			s.StrictDynamic = true
 			return nil
 		case "'report-sample'":
-			// TODO: implement report-sample
+			s.ReportSample = true
 			return nil
 		}
 
-		parts := strings.Split(source[1:len(source)-1], "-")
+		// Nonces and hashes may contain "-" as it's part of the base64-value
+		// grammar.
+		parts := strings.SplitN(source[1:len(source)-1], "-", 2)
 		if len(parts) == 2 {
 			val := parts[1]
+			_, isHash := hashAlgorithms[parts[0]]
+			if (parts[0] == "nonce" || isHash) && !base64ValueRegex.MatchString(val) {
+				return errors.Errorf("invalid base64 value in source %q", source)
+			}
 
-			var alg func() hash.Hash
-			switch parts[0] {
-			case "nonce":
+			if parts[0] == "nonce" {
 				s.Nonces[val] = true
 				return nil
-
-			case "sha256":
-				alg = sha256.New
-			case "sha384":
-				alg = sha512.New384
-			case "sha512":
-				alg = sha512.New
 			}
-			if alg != nil {
+			if alg, ok := hashAlgorithms[parts[0]]; ok {
 				s.Hashes = append(s.Hashes, HashSource{
+					Name:      parts[0],
 					Algorithm: alg,
 					Value:     val,
 				})
@@ -206,32 +324,84 @@ func (s *SourceDirective) ParseSource(source string) error {
 			return nil
 		}
 		if hostSchemeRegex.MatchString(source) {
-			{
-				g, err := glob.Compile(sanitizeGlob(source), '/')
-				if err != nil {
-					return err
-				}
-				s.Hosts = append(s.Hosts, g)
-			}
-			{
-				g, err := glob.Compile("*://"+sanitizeGlob(source), '/')
-				if err != nil {
-					return err
-				}
-				s.Hosts = append(s.Hosts, g)
+			h, err := ParseHostSource(source)
+			if err != nil {
+				return err
 			}
+			s.Hosts = append(s.Hosts, h)
 			return nil
 		}
 	}
 	return errors.Errorf("unknown source %q", source)
 }
 
-func sanitizeGlob(pattern string) string {
-	parts := strings.Split(pattern, "*")
-	for i, part := range parts {
-		parts[i] = glob.QuoteMeta(part)
+// String returns the source list of the directive in a normalized order:
+// keywords, nonces, hashes, schemes and finally hosts. Sources of each kind are
+// sorted and duplicates are removed, so equivalent directives serialize the
+// same.
+func (s SourceDirective) String() string {
+	if s.None {
+		return "'none'"
+	}
+
+	var sources []string
+	keywords := []struct {
+		enabled bool
+		source  string
+	}{
+		{s.Self, "'self'"},
+		{s.UnsafeInline, "'unsafe-inline'"},
+		{s.UnsafeEval, "'unsafe-eval'"},
+		{s.UnsafeHashes, "'unsafe-hashes'"},
+		{s.StrictDynamic, "'strict-dynamic'"},
+		{s.ReportSample, "'report-sample'"},
+	}
+	for _, keyword := range keywords {
+		if keyword.enabled {
+			sources = append(sources, keyword.source)
+		}
+	}
+
+	var nonces []string
+	for nonce, ok := range s.Nonces {
+		if ok {
+			nonces = append(nonces, "'nonce-"+nonce+"'")
+		}
+	}
+	sources = append(sources, sortedSources(nonces)...)
+
+	var hashes []string
+	for _, hash := range s.Hashes {
+		hashes = append(hashes, hash.String())
+	}
+	sources = append(sources, sortedSources(hashes)...)
+
+	var schemes []string
+	for scheme, ok := range s.Schemes {
+		if ok {
+			schemes = append(schemes, scheme+":")
+		}
+	}
+	sources = append(sources, sortedSources(schemes)...)
+
+	var hosts []string
+	for _, host := range s.Hosts {
+		hosts = append(hosts, host.String())
+	}
+	sources = append(sources, sortedSources(hosts)...)
+	return strings.Join(sources, " ")
+}
+
+// sortedSources sorts sources and removes the duplicates.
+func sortedSources(sources []string) []string {
+	sort.Strings(sources)
+	var unique []string
+	for i, source := range sources {
+		if i == 0 || source != sources[i-1] {
+			unique = append(unique, source)
+		}
 	}
-	return strings.Join(parts, "*")
+	return unique
 }
 
 // Validate checks the source policy to make sure it's valid.
@@ -0,0 +1,157 @@
+package csp
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IntegrityMetadata is a hash from the integrity attribute of a script or
+// stylesheet used for Subresource Integrity.
+type IntegrityMetadata struct {
+	// Algorithm is the name of the hash algorithm, such as sha384.
+	Algorithm string
+	Value     string
+}
+
+// ParseIntegrity parses the value of an integrity attribute. As required by
+// the SRI spec, items with unsupported algorithms or that are malformed are
+// ignored.
+func ParseIntegrity(integrity string) []IntegrityMetadata {
+	var metadata []IntegrityMetadata
+	for _, item := range strings.Fields(integrity) {
+		// Options are separated by "?" and don't affect matching.
+		item = strings.SplitN(item, "?", 2)[0]
+		parts := strings.SplitN(item, "-", 2)
+		if len(parts) != 2 || len(parts[1]) == 0 {
+			continue
+		}
+		alg := strings.ToLower(parts[0])
+		if _, ok := hashAlgorithms[alg]; !ok {
+			continue
+		}
+		metadata = append(metadata, IntegrityMetadata{
+			Algorithm: alg,
+			Value:     parts[1],
+		})
+	}
+	return metadata
+}
+
+// matchesIntegrity returns whether every item of the integrity metadata of
+// ctx is listed as a hash source. CSP3 allows external scripts that match.
+func (s SourceDirective) matchesIntegrity(ctx SourceContext) bool {
+	if len(ctx.Integrity) == 0 {
+		return false
+	}
+	for _, m := range ctx.Integrity {
+		var found bool
+		for _, hash := range s.Hashes {
+			if hash.Name == m.Algorithm && hash.Value == m.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// integrityStrength orders the hash algorithms from weakest to strongest.
+var integrityStrength = map[string]int{
+	"sha256": 1,
+	"sha384": 2,
+	"sha512": 3,
+}
+
+// matchesBody returns whether body matches the integrity metadata. As in
+// browsers, only the metadata using the strongest algorithm is used and body
+// has to match one of them.
+func matchesBody(metadata []IntegrityMetadata, body []byte) bool {
+	var strongest string
+	for _, m := range metadata {
+		if integrityStrength[m.Algorithm] > integrityStrength[strongest] {
+			strongest = m.Algorithm
+		}
+	}
+	for _, m := range metadata {
+		if m.Algorithm != strongest {
+			continue
+		}
+		h := hashAlgorithms[m.Algorithm]()
+		// Writes to a hash.Hash never return an error.
+		_, _ = h.Write(body)
+		if base64.StdEncoding.EncodeToString(h.Sum(nil)) == m.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireSRIForDirective is the require-sri-for directive, which requires
+// external scripts and/or stylesheets to have integrity metadata.
+type RequireSRIForDirective struct {
+	Script bool
+	Style  bool
+}
+
+// ParseRequireSRIForDirective parses the resource types of a require-sri-for
+// directive.
+func ParseRequireSRIForDirective(types []string) (RequireSRIForDirective, error) {
+	var d RequireSRIForDirective
+	if len(types) == 0 {
+		return d, errors.Errorf("require-sri-for expects at least 1 field")
+	}
+	for _, t := range types {
+		switch t {
+		case "script":
+			d.Script = true
+		case "style":
+			d.Style = true
+		default:
+			return RequireSRIForDirective{}, errors.Errorf("unknown require-sri-for type %q", t)
+		}
+	}
+	return d, nil
+}
+
+// Check implements Directive. Inline content and contexts with integrity
+// metadata are always allowed.
+func (d RequireSRIForDirective) Check(p Policy, ctx SourceContext) (bool, error) {
+	if ctx.UnsafeInline || len(ctx.Integrity) > 0 {
+		return true, nil
+	}
+	if ctx.Script && d.Script || ctx.Style && d.Style {
+		return false, nil
+	}
+	return true, nil
+}
+
+// String returns the resource types of the directive.
+func (d RequireSRIForDirective) String() string {
+	var types []string
+	if d.Script {
+		types = append(types, "script")
+	}
+	if d.Style {
+		types = append(types, "style")
+	}
+	return strings.Join(types, " ")
+}
+
+// checkIntegrity checks an external script or stylesheet against
+// require-sri-for.
+func checkIntegrity(p Policy, ctx SourceContext) ([]Report, error) {
+	d, ok := p.Directives["require-sri-for"].(RequireSRIForDirective)
+	if !ok {
+		return nil, nil
+	}
+	allowed, err := d.Check(p, ctx)
+	if err != nil || allowed {
+		return nil, err
+	}
+	return []Report{p.report(ctx, "require-sri-for", d)}, nil
+}
@@ -0,0 +1,27 @@
+package csp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIntegrity(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		integrity string
+		want      []IntegrityMetadata
+	}{
+		{"", nil},
+		{"sha384-abc", []IntegrityMetadata{{"sha384", "abc"}}},
+		{" SHA256-abc?foo  sha512-def ", []IntegrityMetadata{{"sha256", "abc"}, {"sha512", "def"}}},
+		{"md5-abc sha1-def sha256- foo", nil},
+	}
+
+	for i, c := range cases {
+		got := ParseIntegrity(c.integrity)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%d. ParseIntegrity(%q) = %+v; not %+v", i, c.integrity, got, c.want)
+		}
+	}
+}